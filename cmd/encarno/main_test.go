@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encarno/pkg/cli"
 	"encarno/pkg/core"
 	"os"
 	"testing"
@@ -10,8 +11,8 @@ import (
 func TestConfig(t *testing.T) {
 	cfg := "/media/BIG/bzt-artifacts/some/encarno_cfg.yaml"
 	if _, err := os.Stat(cfg); err == nil {
-		config := LoadConfig(cfg)
-		Run(config)
+		config := cli.LoadConfig(cfg)
+		cli.Run(config)
 	}
 }
 
@@ -44,7 +45,7 @@ func TestOpen(t *testing.T) {
 		},
 		Protocol: core.ProtoConf{Driver: "http"},
 	}
-	Run(c)
+	cli.Run(c)
 }
 
 func TestClosed(t *testing.T) {
@@ -84,5 +85,5 @@ func TestClosed(t *testing.T) {
 		},
 		Protocol: core.ProtoConf{Driver: "dummy"},
 	}
-	Run(c)
+	cli.Run(c)
 }
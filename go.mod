@@ -3,9 +3,13 @@ module encarno
 go 1.20
 
 require (
+	github.com/andybalholm/brotli v1.1.0
+	github.com/klauspost/compress v1.17.9
 	github.com/sirupsen/logrus v1.8.1
 	golang.org/x/exp v0.0.0-20220609121020-a51bd0440498
+	golang.org/x/net v0.23.0
+	golang.org/x/sys v0.18.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
-require golang.org/x/sys v0.1.0 // indirect
+require golang.org/x/text v0.14.0 // indirect
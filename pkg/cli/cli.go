@@ -0,0 +1,165 @@
+package cli
+
+import (
+	"bytes"
+	"encarno/pkg/core"
+	_ "encarno/pkg/grpc" // built-in protocol drivers register themselves
+	_ "encarno/pkg/http"
+	"encarno/pkg/scenario"
+	_ "encarno/pkg/tcp"
+	_ "encarno/pkg/udp"
+	_ "encarno/pkg/websocket"
+	"flag"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+var controller core.WorkerSpawner
+
+// Main is the whole command-line tool, custom builds may register own protocol drivers and call it from their main()
+func Main() {
+	log.SetOutput(&OutputSplitter{})
+	if os.Getenv("DEBUG") == "" {
+		log.SetLevel(log.InfoLevel)
+	} else {
+		log.SetLevel(log.DebugLevel)
+	}
+	log.Infof("Encarno v0.0")
+
+	handleSignals()
+
+	help := flag.Bool("help", false, "Show help")
+	flag.Usage = usage
+
+	flag.Parse()
+
+	if *help {
+		flag.Usage()
+		os.Exit(0)
+	}
+
+	if flag.NArg() < 1 {
+		fmt.Println("Missing configuration file path")
+		fmt.Println()
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	config := LoadConfig(flag.Arg(0))
+	Run(config)
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	_, _ = fmt.Fprintf(out, "Usage: %s [options] <config.yaml>\n", os.Args[0])
+	flag.PrintDefaults()
+	_, _ = fmt.Fprintf(out, "\nProtocol drivers:\n%s\n", core.NibsUsage())
+}
+
+func LoadConfig(path string) core.Configuration {
+	log.Infof("Loading config file: %s", path)
+	yamlFile, err := ioutil.ReadFile(path)
+	if err != nil {
+		panic(err)
+	}
+
+	cfg := core.Configuration{
+		Protocol: core.ProtoConf{
+			Timeout: 1 * time.Second,
+		},
+	}
+	err = yaml.Unmarshal(yamlFile, &cfg)
+	if err != nil {
+		panic(err)
+	}
+
+	_, err = core.DecodeProtoOptions(cfg.Protocol)
+	if err != nil {
+		panic(err)
+	}
+	return cfg
+}
+
+var alreadyHandlingSignal = false
+
+func handleSignals() {
+	signalChanel := make(chan os.Signal, 1)
+	signal.Notify(signalChanel,
+		syscall.SIGHUP,
+		syscall.SIGINT,
+		syscall.SIGTERM,
+		syscall.SIGQUIT)
+
+	go func() {
+		select {
+		case s := <-signalChanel:
+			log.Infof("Got signal %d: %v", s, s)
+			if !alreadyHandlingSignal {
+				alreadyHandlingSignal = true
+
+				if controller != nil {
+					controller.Interrupt()
+				}
+			}
+			os.Exit(2)
+		}
+	}()
+}
+
+func Run(config core.Configuration) {
+	status := core.NewStatus()
+	status.Start()
+
+	if config.Input.StringsFile != "" && config.Output.StringsFile != "" {
+		input, err := ioutil.ReadFile(config.Input.StringsFile)
+		if err != nil {
+			panic(err)
+		}
+
+		err = ioutil.WriteFile(config.Output.StringsFile, input, 0644)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	output := core.NewOutput(config.Output)
+	defer output.Close()
+
+	nibMaker, err := core.NewNibMaker(config.Protocol)
+	if err != nil {
+		panic(err)
+	}
+
+	controller = NewWorkload(config.Workers, config.Input, nibMaker, output, status)
+	controller.Run()
+}
+
+func NewWorkload(workersConf core.WorkerConf, inputConfig core.InputConf, nibMaker core.NibMaker, output *core.Output, status *core.Status) core.WorkerSpawner {
+	base := core.NewBaseWorkload(nibMaker, output, inputConfig, workersConf, status)
+	switch workersConf.Mode {
+	case core.WorkloadOpen, core.WorkloadFile:
+		return scenario.NewOpenWorkload(workersConf, base)
+	case core.WorkloadClosed:
+		return scenario.NewClosedWorkload(inputConfig, base)
+	default:
+		panic(fmt.Sprintf("Unsupported workers mode: %s", workersConf.Mode))
+	}
+}
+
+type OutputSplitter struct{}
+
+func (splitter *OutputSplitter) Write(p []byte) (n int, err error) {
+	if bytes.Contains(p, []byte("level=error")) || bytes.Contains(p, []byte("level=warning")) {
+		n, err = os.Stderr.Write(p)
+		if err != nil {
+			return n, err
+		}
+	}
+	return os.Stdout.Write(p)
+}
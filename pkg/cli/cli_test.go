@@ -0,0 +1,42 @@
+package cli
+
+import (
+	"encarno/pkg/core"
+	"encarno/pkg/scenario"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewWorkload(t *testing.T) {
+	inp := core.InputConf{Predefined: make(core.InputChannel)}
+	maker := func() core.Nib { return nil }
+	if _, ok := NewWorkload(core.WorkerConf{Mode: core.WorkloadOpen}, inp, maker, nil, &core.Status{}).(*scenario.OpenWorkload); !ok {
+		t.Errorf("Open mode should make open workload")
+	}
+
+	if _, ok := NewWorkload(core.WorkerConf{Mode: core.WorkloadClosed}, inp, maker, nil, &core.Status{}).(*scenario.ClosedWorkload); !ok {
+		t.Errorf("Closed mode should make closed workload")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Unknown mode should be rejected")
+		}
+	}()
+	NewWorkload(core.WorkerConf{Mode: "nope"}, inp, maker, nil, &core.Status{})
+}
+
+func TestLoadConfigOptions(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(fname, []byte("protocol:\n  driver: tcp\n  options:\n    nope: 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Unknown driver option should be rejected")
+		}
+	}()
+	LoadConfig(fname)
+}
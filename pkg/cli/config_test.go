@@ -0,0 +1,28 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfigDefaults(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(fname, []byte("protocol:\n  driver: dummy\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := LoadConfig(fname)
+	if cfg.Protocol.MaxConnections != 0 {
+		t.Errorf("Connections per host should not be limited by default, got %d", cfg.Protocol.MaxConnections)
+	}
+
+	if err := os.WriteFile(fname, []byte("protocol:\n  driver: dummy\n  maxconnections: 3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg = LoadConfig(fname)
+	if cfg.Protocol.MaxConnections != 3 {
+		t.Errorf("Explicit limit should be kept, got %d", cfg.Protocol.MaxConnections)
+	}
+}
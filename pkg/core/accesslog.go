@@ -0,0 +1,203 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net/url"
+	"regexp"
+	"time"
+)
+
+// DefaultLogRegex matches common and combined log formats of nginx and Apache
+const DefaultLogRegex = `^\S+ \S+ \S+ \[(?P<time>[^\]]+)\] "(?P<method>[A-Z]+) (?P<url>\S+)[^"]*" \d+ \S+(?: "(?P<referer>[^"]*)" "(?P<agent>[^"]*)")?`
+
+const DefaultLogTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+type AccessLogConf struct {
+	Regex      string // named groups: time and url, optional method, referer and agent
+	TimeFormat string // Go layout for time group
+	Address    string // base address for relative URLs, like http://localhost:8080
+}
+
+type accessLogReader struct {
+	re   *regexp.Regexp
+	conf AccessLogConf
+}
+
+func newAccessLogReader(conf AccessLogConf) *accessLogReader {
+	if conf.Regex == "" {
+		conf.Regex = DefaultLogRegex
+	}
+
+	if conf.TimeFormat == "" {
+		conf.TimeFormat = DefaultLogTimeFormat
+	}
+
+	re := regexp.MustCompile(conf.Regex)
+	if re.SubexpIndex("time") < 0 || re.SubexpIndex("url") < 0 {
+		panic(fmt.Sprintf("Access log regex needs 'time' and 'url' named groups: %s", conf.Regex))
+	}
+	return &accessLogReader{re: re, conf: conf}
+}
+
+// read converts log lines into payload records as they come, offset of each is its time since the first line.
+// Gives the number of good and bad lines.
+func (r *accessLogReader) read(fname string, send func(item *PayloadItem)) (int, int) {
+	log.Infof("Reading access log input file: %s", fname)
+	file, err := openInput(fname)
+	if err != nil {
+		panic(err)
+	}
+	defer file.Close()
+
+	var first time.Time
+	good := 0
+	bad := 0
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 4096), 1024*1024)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+
+		item, ts, err := accessLogItem(r.re, r.conf, line)
+		if err != nil {
+			log.Debugf("Skipping access log line %d: %s", lineNo, err)
+			bad++
+			continue
+		}
+
+		if first.IsZero() {
+			first = ts
+		}
+
+		item.Offset = float64(ts.Sub(first)) / float64(time.Millisecond)
+		good++
+		send(item)
+	}
+
+	if err := scanner.Err(); err != nil {
+		panic(err)
+	}
+
+	if good == 0 || bad > good {
+		panic(fmt.Sprintf("Access log file is problematic: %d good lines and %d bad lines read", good, bad))
+	}
+	return good, bad
+}
+
+// newAccessLogCache keeps access log records in memory, for input orders that need it
+func newAccessLogCache(fname string, conf AccessLogConf) *payloadCache {
+	cache := &payloadCache{items: make([]*PayloadItem, 0)}
+	_, bad := newAccessLogReader(conf).read(fname, func(item *PayloadItem) {
+		cache.items = append(cache.items, item)
+	})
+	log.Infof("Loaded %d requests from access log, %d lines skipped", len(cache.items), bad)
+	return cache
+}
+
+func accessLogItem(re *regexp.Regexp, conf AccessLogConf, line string) (*PayloadItem, time.Time, error) {
+	match := re.FindStringSubmatch(line)
+	if match == nil {
+		return nil, time.Time{}, errors.New("line does not match regex")
+	}
+
+	group := func(name string) string {
+		idx := re.SubexpIndex(name)
+		if idx < 0 {
+			return ""
+		}
+		return match[idx]
+	}
+
+	ts, err := time.Parse(conf.TimeFormat, group("time"))
+	if err != nil {
+		return nil, ts, err
+	}
+
+	parsed, err := url.Parse(group("url"))
+	if err != nil {
+		return nil, ts, err
+	}
+
+	address := conf.Address
+	if parsed.IsAbs() {
+		address = parsed.Scheme + "://" + parsed.Host
+	} else if address == "" {
+		return nil, ts, errors.New(fmt.Sprintf("relative URL needs address configured: %s", parsed))
+	}
+
+	base, err := url.Parse(address)
+	if err != nil {
+		return nil, ts, err
+	}
+
+	method := group("method")
+	if method == "" {
+		method = "GET"
+	}
+
+	buf := bytes.Buffer{}
+	buf.WriteString(method + " " + parsed.RequestURI() + " HTTP/1.1\r\n")
+	buf.WriteString("Host: " + base.Host + "\r\n")
+	if referer := group("referer"); referer != "" && referer != "-" {
+		buf.WriteString("Referer: " + referer + "\r\n")
+	}
+
+	if agent := group("agent"); agent != "" && agent != "-" {
+		buf.WriteString("User-Agent: " + agent + "\r\n")
+	}
+
+	if method == "POST" || method == "PUT" || method == "PATCH" { // bodies are not logged
+		buf.WriteString("Content-Length: 0\r\n")
+	}
+	buf.WriteString("\r\n")
+
+	item := &PayloadItem{
+		Label:    parsed.Path,
+		Address:  address,
+		Payload:  buf.Bytes(),
+		RegexOut: map[string]*ExtractRegex{},
+	}
+	item.PayloadLen = len(item.Payload)
+	return item, ts, nil
+}
+
+// accessLogInput parses log lines lazily while sending them, unless records are reordered from memory
+func accessLogInput(config InputConf) InputChannel {
+	if inputCached(config) {
+		cache := getPayloadCache(config.PayloadFile, func() *payloadCache {
+			return newAccessLogCache(config.PayloadFile, config.AccessLog)
+		})
+		return cache.loop(config)
+	}
+
+	reader := newAccessLogReader(config.AccessLog)
+	rewindable := inputRewindable(config.PayloadFile)
+	if !rewindable && config.IterationLimit != 1 {
+		log.Warningf("Access log input %s is a stream, it will be read once without looping", config.PayloadFile)
+	}
+
+	ch := make(InputChannel)
+	go func() {
+		iterations := 0
+		for {
+			good, bad := reader.read(config.PayloadFile, func(item *PayloadItem) {
+				ch <- item
+			})
+			log.Debugf("Read %d requests from access log, %d lines skipped", good, bad)
+
+			iterations++
+			if !rewindable || config.IterationLimit > 0 && iterations >= config.IterationLimit {
+				break
+			}
+		}
+		log.Infof("Input exhausted")
+		close(ch)
+	}()
+	return ch
+}
@@ -0,0 +1,138 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testAccessLog = `10.0.0.1 - - [10/Jan/2023:10:00:00 +0000] "GET /index.html?q=1 HTTP/1.1" 200 612 "-" "Mozilla/5.0 (X11)"
+10.0.0.2 - bob [10/Jan/2023:10:00:02 +0000] "POST /login HTTP/1.1" 302 0 "http://example.com/" "curl/7.68.0"
+garbage line
+10.0.0.3 - - [10/Jan/2023:10:00:01 +0000] "GET http://other.com:8080/img.png HTTP/1.0" 404 -
+10.0.0.1 - - [10/Jan/2023:10:00:06 +0000] "HEAD / HTTP/1.1" 200 0
+`
+
+func TestAccessLogInput(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "access.log")
+	if err := os.WriteFile(fname, []byte(testAccessLog), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	conf := InputConf{
+		PayloadFile:    fname,
+		Format:         InputFormatAccessLog,
+		AccessLog:      AccessLogConf{Address: "https://example.com"},
+		IterationLimit: 2,
+		Speed:          2,
+	}
+
+	items := make([]*PayloadItem, 0)
+	offsets := make([]time.Duration, 0)
+	input, schedule := NewInputSchedule(conf)
+	for offset := range schedule {
+		offsets = append(offsets, offset)
+		items = append(items, <-input)
+	}
+
+	if len(items) != 8 || len(offsets) != 8 {
+		t.Fatalf("Wrong items and schedule length: %d, %d", len(items), len(offsets))
+	}
+
+	expected := []struct {
+		label   string
+		address string
+		payload string
+		offset  time.Duration
+	}{
+		{"/index.html", "https://example.com",
+			"GET /index.html?q=1 HTTP/1.1\r\nHost: example.com\r\nUser-Agent: Mozilla/5.0 (X11)\r\n\r\n", 0},
+		{"/login", "https://example.com",
+			"POST /login HTTP/1.1\r\nHost: example.com\r\nReferer: http://example.com/\r\nUser-Agent: curl/7.68.0\r\nContent-Length: 0\r\n\r\n", time.Second},
+		{"/img.png", "http://other.com:8080", "GET /img.png HTTP/1.1\r\nHost: other.com:8080\r\n\r\n", time.Second},
+		{"/", "https://example.com", "HEAD / HTTP/1.1\r\nHost: example.com\r\n\r\n", 3 * time.Second},
+	}
+
+	for n, item := range items {
+		exp := expected[n%4]
+		if item.Label != exp.label || item.Address != exp.address || string(item.Payload) != exp.payload {
+			t.Errorf("Wrong item %d: %s, %s, %q", n, item.Label, item.Address, item.Payload)
+		}
+
+		if offsets[n] != exp.offset+time.Duration(n/4)*3*time.Second {
+			t.Errorf("Wrong offset %d: %v", n, offsets[n])
+		}
+	}
+}
+
+func TestAccessLogRegex(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "custom.log")
+	data := "2023-01-10T10:00:00.000Z DELETE /items/1\n2023-01-10T10:00:00.250Z /items\n"
+	if err := os.WriteFile(fname, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cache := newAccessLogCache(fname, AccessLogConf{
+		Regex:      `^(?P<time>\S+) (?:(?P<method>[A-Z]+) )?(?P<url>\S+)`,
+		TimeFormat: time.RFC3339,
+		Address:    "http://localhost",
+	})
+
+	if len(cache.items) != 2 || cache.items[1].Offset != 250 {
+		t.Fatalf("Wrong records: %d, %v", len(cache.items), cache.items[1].Offset)
+	}
+
+	if string(cache.items[0].Payload) != "DELETE /items/1 HTTP/1.1\r\nHost: localhost\r\n\r\n" ||
+		string(cache.items[1].Payload) != "GET /items HTTP/1.1\r\nHost: localhost\r\n\r\n" {
+		t.Errorf("Wrong payloads: %q, %q", cache.items[0].Payload, cache.items[1].Payload)
+	}
+}
+
+func TestAccessLogStream(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdin := os.Stdin
+	os.Stdin = r
+	defer func() {
+		os.Stdin = stdin
+		_ = r.Close()
+	}()
+
+	lines := strings.SplitAfter(testAccessLog, "\n")
+	_, _ = w.Write([]byte(lines[0]))
+
+	conf := InputConf{PayloadFile: StdinInput, Format: InputFormatAccessLog, AccessLog: AccessLogConf{Address: "http://localhost"}}
+	if !readOnce(conf) {
+		t.Errorf("Access log stream should be read once")
+	}
+
+	input := NewInput(conf)
+	select {
+	case item := <-input:
+		if item.Label != "/index.html" {
+			t.Errorf("Wrong first item: %s", item.Label)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Lines should be parsed while the log is still written")
+	}
+
+	_, _ = w.Write([]byte(strings.Join(lines[1:], "")))
+	_ = w.Close()
+
+	cnt := 1
+	for item := range input {
+		cnt++
+		if cnt == 4 && item.Offset != 6000 {
+			t.Errorf("Offset should be counted from the first line: %v", item.Offset)
+		}
+	}
+
+	if cnt != 4 {
+		t.Errorf("Stream should be read once: %d", cnt)
+	}
+}
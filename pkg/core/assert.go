@@ -0,0 +1,182 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Assertion kinds, regex is the default
+const (
+	AssertRegexKind    = "regex"
+	AssertStatusKind   = "status"
+	AssertElapsedKind  = "elapsed"
+	AssertSizeKind     = "size"
+	AssertHeaderKind   = "header"
+	AssertJSONPathKind = "jsonpath"
+)
+
+type AssertItem struct {
+	Kind   string // regex by default, or one of status, elapsed, size, header and jsonpath
+	Re     *RegexpProxy
+	Invert bool
+	Expr   string  // status list like 200-299,304, max elapsed duration, size range, header name or JSONPath
+	Value  *string // expected header or JSONPath value, presence is checked if nil
+
+	ranges  [][2]uint64
+	elapsed time.Duration
+	path    *JSONPath
+}
+
+// prepare checks the assertion and parses its expression
+func (a *AssertItem) prepare() error {
+	var err error
+	switch a.Kind {
+	case "", AssertRegexKind:
+		if a.Re == nil || a.Re.Regexp == nil {
+			return errors.New("Regex assertion needs 're'")
+		}
+	case AssertStatusKind:
+		a.ranges, err = parseRanges(a.Expr, false)
+	case AssertSizeKind:
+		a.ranges, err = parseRanges(a.Expr, true)
+	case AssertElapsedKind:
+		a.elapsed, err = time.ParseDuration(a.Expr)
+	case AssertHeaderKind:
+		if a.Expr == "" {
+			err = errors.New("Header assertion needs name in 'expr'")
+		}
+	case AssertJSONPathKind:
+		a.path, err = CompileJSONPath(a.Expr)
+	default:
+		err = errors.New(fmt.Sprintf("Unknown assertion kind: %s", a.Kind))
+	}
+	return err
+}
+
+// parseRanges reads comma-separated list of numbers and ranges, open ranges like 1- and -100 are allowed if openEnded
+func parseRanges(s string, openEnded bool) ([][2]uint64, error) {
+	res := make([][2]uint64, 0)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		from, to, isRange := strings.Cut(part, "-")
+
+		r := [2]uint64{0, ^uint64(0)}
+		var err error
+		if from != "" || !openEnded {
+			r[0], err = strconv.ParseUint(strings.TrimSpace(from), 10, 64)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("Invalid range '%s': %s", part, err))
+			}
+		}
+
+		if !isRange {
+			r[1] = r[0]
+		} else if to != "" || !openEnded {
+			r[1], err = strconv.ParseUint(strings.TrimSpace(to), 10, 64)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("Invalid range '%s': %s", part, err))
+			}
+		}
+		res = append(res, r)
+	}
+	return res, nil
+}
+
+func inRanges(val uint64, ranges [][2]uint64) bool {
+	for _, r := range ranges {
+		if val >= r[0] && val <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// check tells if the assertion condition holds, and what was actually found for error message
+func (a *AssertItem) check(item *OutputItem, resp func() *parsedResponse) (bool, string) {
+	switch a.Kind {
+	case AssertStatusKind:
+		return inRanges(uint64(item.Status), a.ranges), "got " + strconv.Itoa(int(item.Status))
+	case AssertSizeKind:
+		return inRanges(item.RespBytesCount, a.ranges), "got " + strconv.FormatUint(item.RespBytesCount, 10) + " bytes"
+	case AssertElapsedKind:
+		return item.Elapsed <= a.elapsed, "took " + item.Elapsed.String()
+	case AssertHeaderKind:
+		return valuesCheck(resp().headerValues(a.Expr), a.Value)
+	case AssertJSONPathKind:
+		return valuesCheck(resp().jsonValues(a.path), a.Value)
+	}
+	return false, ""
+}
+
+func valuesCheck(values [][]byte, expected *string) (bool, string) {
+	if len(values) == 0 {
+		return false, "got nothing"
+	}
+
+	if expected == nil {
+		return true, "got '" + string(values[0]) + "'"
+	}
+
+	for _, val := range values {
+		if string(val) == *expected {
+			return true, "got '" + string(val) + "'"
+		}
+	}
+	return false, "got '" + string(values[0]) + "'"
+}
+
+func (a *AssertItem) String() string {
+	switch a.Kind {
+	case "", AssertRegexKind:
+		return "regexp: " + a.Re.String()
+	case AssertHeaderKind, AssertJSONPathKind:
+		if a.Value != nil {
+			return a.Kind + " " + a.Expr + "=" + *a.Value
+		}
+	}
+	return a.Kind + " " + a.Expr
+}
+
+func (i *OutputItem) Assert(asserts []*AssertItem) {
+	problems := ""
+	if i.Error != nil {
+		problems = i.Error.Error()
+	}
+
+	var parsed *parsedResponse // parsed only when needed
+	resp := func() *parsedResponse {
+		if parsed == nil {
+			parsed = parseResponse(i.matchData())
+		}
+		return parsed
+	}
+
+	for _, a := range asserts {
+		var holds bool
+		details := ""
+		if a.Kind == "" || a.Kind == AssertRegexKind {
+			holds = a.Re.Find(i.matchData()) != nil
+		} else {
+			holds, details = a.check(i, resp)
+		}
+
+		if holds == a.Invert {
+			inv := ""
+			if a.Invert {
+				inv = "inverted "
+			}
+
+			problems += fmt.Sprintf("\nAssert failed on %s%s", inv, a)
+			if details != "" {
+				problems += ", " + details
+			}
+		}
+	}
+
+	if problems != "" {
+		i.Error = errors.New(strings.TrimSpace(problems))
+	}
+}
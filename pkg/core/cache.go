@@ -0,0 +1,167 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"sync"
+)
+
+// payloadCache holds all records of payload file, with payloads pointing into file contents mapped into memory
+type payloadCache struct {
+	file  *os.File
+	data  []byte
+	items []*PayloadItem
+}
+
+var payloadCaches = map[string]*payloadCache{}
+var payloadCachesMx sync.Mutex
+
+// getPayloadCache loads file once, so workers having own inputs for regexes share it
+func getPayloadCache(fname string, load func() *payloadCache) *payloadCache {
+	payloadCachesMx.Lock()
+	defer payloadCachesMx.Unlock()
+
+	if cache, ok := payloadCaches[fname]; ok {
+		return cache
+	}
+
+	cache := load()
+	payloadCaches[fname] = cache
+	return cache
+}
+
+func newPayloadCache(fname string, index *StrIndex) *payloadCache {
+	log.Infof("Loading payload input file into memory: %s", fname)
+	cache := &payloadCache{items: make([]*PayloadItem, 0)}
+	if inputPlain(fname) {
+		file, err := os.Open(fname)
+		if err != nil {
+			panic(err)
+		}
+
+		cache.file = file
+		cache.data, err = mapFile(file)
+		if err != nil {
+			panic(err)
+		}
+	} else { // streams and compressed files are read whole, no sendfile for them
+		input, err := openInput(fname)
+		if err != nil {
+			panic(err)
+		}
+
+		cache.data, err = io.ReadAll(input)
+		_ = input.Close()
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	src := bytes.NewReader(cache.data)
+	reader := bufio.NewReaderSize(src, inputBufferSize)
+	bad := 0
+	for n := 0; ; n++ {
+		item, err := readRecord(reader, index, cache.slicePayload(src))
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			log.Errorf("Failed to read payload record #%d: %s", n, err)
+			bad++
+			continue
+		}
+		cache.items = append(cache.items, item)
+	}
+
+	if len(cache.items) == 0 || bad > len(cache.items) {
+		panic(fmt.Sprintf("Payload input file is problematic: %d good records and %d bad records read", len(cache.items), bad))
+	}
+
+	log.Infof("Loaded %d payload records, %d bytes", len(cache.items), len(cache.data))
+	return cache
+}
+
+// slicePayload takes payload from file contents without copying, skipping it in src directly if it is not buffered
+func (c *payloadCache) slicePayload(src *bytes.Reader) payloadReader {
+	return func(reader *bufio.Reader, item *PayloadItem, plen int) error {
+		pos := int64(len(c.data)) - int64(src.Len()) - int64(reader.Buffered())
+		if pos+int64(plen) > int64(len(c.data)) {
+			_, _ = src.Seek(0, io.SeekEnd)
+			reader.Reset(src)
+			return io.ErrUnexpectedEOF
+		}
+
+		item.Payload = c.data[pos : pos+int64(plen)]
+		item.SourceFile = c.file
+		item.SourceOffset = pos
+		item.borrowed = true
+
+		if plen <= reader.Buffered() {
+			_, err := reader.Discard(plen)
+			return err
+		}
+
+		_, err := src.Seek(pos+int64(plen), io.SeekStart)
+		reader.Reset(src)
+		return err
+	}
+}
+
+// get gives pooled copy of the record, payload bytes are shared
+func (c *payloadCache) get(n int) *PayloadItem {
+	src := c.items[n]
+	item := NewPayloadItem()
+	item.LabelIdx = src.LabelIdx
+	item.Label = src.Label
+	item.AddressIdx = src.AddressIdx
+	item.Address = src.Address
+	item.PayloadLen = src.PayloadLen
+	item.Payload = src.Payload
+	item.Replaces = append(item.Replaces, src.Replaces...)
+	item.Asserts = append(item.Asserts, src.Asserts...)
+	for k, v := range src.RegexOut {
+		item.RegexOut[k] = v
+	}
+	item.StrIndex = src.StrIndex
+	item.SourceFile = src.SourceFile
+	item.SourceOffset = src.SourceOffset
+	item.ThinkTime = src.ThinkTime
+	item.Offset = src.Offset
+	item.Weight = src.Weight
+	item.borrowed = true
+	return item
+}
+
+func cachedInput(config InputConf, index *StrIndex) InputChannel {
+	cache := getPayloadCache(config.PayloadFile, func() *payloadCache {
+		return newPayloadCache(config.PayloadFile, index)
+	})
+	return cache.loop(config)
+}
+
+// loop sends copies of records in configured order, over and over until iteration limit is reached
+func (c *payloadCache) loop(config InputConf) InputChannel {
+	next := c.orderer(config)
+	ch := make(InputChannel)
+	go func() {
+		iterations := 0
+		idx := make([]int, len(c.items))
+		for {
+			next(idx)
+			for _, n := range idx {
+				ch <- c.get(n)
+			}
+
+			iterations++
+			if config.IterationLimit > 0 && iterations >= config.IterationLimit {
+				break
+			}
+		}
+		log.Infof("Input exhausted")
+		close(ch)
+	}()
+	return ch
+}
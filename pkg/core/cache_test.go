@@ -0,0 +1,60 @@
+package core
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestCachedInput(t *testing.T) {
+	conf := InputConf{
+		PayloadFile:    "../../examples/payload-strings.txt",
+		IterationLimit: 2,
+	}
+	expected := make([]*PayloadItem, 0)
+	for item := range NewInput(conf) {
+		expected = append(expected, item)
+	}
+
+	conf.CacheInMemory = true
+	items := make([]*PayloadItem, 0)
+	for item := range NewInput(conf) {
+		items = append(items, item)
+	}
+
+	if len(items) != len(expected) {
+		t.Fatalf("Wrong items len: %d", len(items))
+	}
+
+	data, err := os.ReadFile(conf.PayloadFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for n, item := range items {
+		exp := expected[n]
+		if item.Label != exp.Label || item.Address != exp.Address || !bytes.Equal(item.Payload, exp.Payload) ||
+			len(item.Replaces) != len(exp.Replaces) || len(item.Asserts) != len(exp.Asserts) || len(item.RegexOut) != len(exp.RegexOut) {
+			t.Errorf("Item %d differs: %+v\n%+v", n, item, exp)
+		}
+
+		region := data[item.SourceOffset : item.SourceOffset+int64(len(item.Payload))]
+		if item.SourceFile == nil || !bytes.Equal(region, item.Payload) {
+			t.Errorf("Item %d has wrong source region", n)
+		}
+	}
+
+	if items[0].RegexOut["etag"].Re.String() != "ETag: (\".+\")" {
+		t.Errorf("Wrong regex read for extractor")
+	}
+
+	items[0].ReplaceValues(ValMap{})
+	if items[0].SourceFile != nil {
+		t.Errorf("Replaced payload should not be sent from file")
+	}
+
+	items[1].Release()
+	if items[1].Payload != nil {
+		t.Errorf("Released item should not keep cached payload")
+	}
+}
@@ -0,0 +1,150 @@
+package core
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"math/rand"
+	"os"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// CSV feed modes, shared is the default
+const (
+	CSVFeedShared  = "shared"
+	CSVFeedSharded = "sharded"
+	CSVFeedRandom  = "random"
+)
+
+type CSVFeedConf struct {
+	File      string
+	Columns   []string // variable names, taken from the first row if empty
+	Delimiter string   // comma by default
+	Mode      string   // shared, sharded or random
+	StopOnEOF bool     // worker stops when rows are exhausted, otherwise rows are reused from start
+	PerWorker bool     // row is loaded once for worker instead of each iteration
+}
+
+// CSVFeed holds rows of CSV file to be loaded into worker values
+type CSVFeed struct {
+	conf    CSVFeedConf
+	columns []string
+	rows    [][][]byte
+	shards  int
+	next    int64
+}
+
+// NewCSVFeed reads the whole file, shards is the number of workers to split rows between in sharded mode
+func NewCSVFeed(conf CSVFeedConf, shards int) (*CSVFeed, error) {
+	feed := &CSVFeed{conf: conf, columns: conf.Columns, shards: shards}
+	switch conf.Mode {
+	case "", CSVFeedShared, CSVFeedRandom:
+	case CSVFeedSharded:
+		if shards <= 0 {
+			return nil, errors.New(fmt.Sprintf("Sharded CSV feed needs limited number of workers: %s", conf.File))
+		}
+	default:
+		return nil, errors.New(fmt.Sprintf("Unknown CSV feed mode: %s", conf.Mode))
+	}
+
+	log.Infof("Reading CSV feed file: %s", conf.File)
+	file, err := os.Open(conf.File)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	if conf.Delimiter != "" {
+		delim, size := utf8.DecodeRuneInString(conf.Delimiter)
+		if size != len(conf.Delimiter) {
+			return nil, errors.New(fmt.Sprintf("CSV delimiter should be single character: %s", conf.Delimiter))
+		}
+		reader.Comma = delim
+	}
+
+	for {
+		record, err := reader.Read()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+
+		if feed.columns == nil {
+			feed.columns = append([]string{}, record...)
+			continue
+		}
+
+		row := make([][]byte, len(record))
+		for n, val := range record {
+			row[n] = []byte(val)
+		}
+		feed.rows = append(feed.rows, row)
+	}
+
+	if len(feed.rows) == 0 {
+		return nil, errors.New(fmt.Sprintf("CSV feed file has no rows: %s", conf.File))
+	}
+	log.Infof("Read %d rows with columns %v from CSV feed", len(feed.rows), feed.columns)
+	return feed, nil
+}
+
+// rowIndex picks the row for worker iteration, both starting from 1, negative index means no row to load
+func (f *CSVFeed) rowIndex(worker int, iteration int) (int, bool) {
+	total := len(f.rows)
+	switch f.conf.Mode {
+	case CSVFeedRandom:
+		return rand.Intn(total), true
+	case CSVFeedSharded:
+		first := (worker - 1) % f.shards
+		if first >= total {
+			log.Debugf("No CSV feed rows for worker %d in %s", worker, f.conf.File)
+			return -1, !f.conf.StopOnEOF
+		}
+
+		pos := iteration - 1
+		shardLen := (total - first + f.shards - 1) / f.shards
+		if pos >= shardLen {
+			if f.conf.StopOnEOF {
+				return 0, false
+			}
+			pos %= shardLen
+		}
+		return first + pos*f.shards, true
+	default:
+		idx := atomic.AddInt64(&f.next, 1) - 1
+		if idx >= int64(total) {
+			if f.conf.StopOnEOF {
+				return 0, false
+			}
+			idx %= int64(total)
+		}
+		return int(idx), true
+	}
+}
+
+// Load puts the row for worker iteration into values, false means rows are exhausted and worker should stop
+func (f *CSVFeed) Load(values ValMap, worker int, iteration int) bool {
+	if f.conf.PerWorker && iteration > 1 {
+		return true
+	}
+
+	idx, ok := f.rowIndex(worker, iteration)
+	if idx < 0 || !ok {
+		return ok
+	}
+
+	for n, val := range f.rows[idx] {
+		if n < len(f.columns) {
+			values[f.columns[n]] = val
+		}
+	}
+	return true
+}
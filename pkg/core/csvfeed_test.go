@@ -0,0 +1,131 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeCSV(t *testing.T, content string) string {
+	fname := filepath.Join(t.TempDir(), "feed.csv")
+	if err := os.WriteFile(fname, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fname
+}
+
+func feedUsers(t *testing.T, feed *CSVFeed, worker int, iterations int) []string {
+	res := make([]string, 0)
+	values := ValMap{}
+	for i := 1; i <= iterations; i++ {
+		if !feed.Load(values, worker, i) {
+			break
+		}
+		res = append(res, string(values["user"]))
+	}
+	return res
+}
+
+func TestCSVFeedShared(t *testing.T) {
+	fname := writeCSV(t, "user,pass\nu1,p1\nu2,\"p,2\"\nu3,p3\n")
+	feed, err := NewCSVFeed(CSVFeedConf{File: fname}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := ValMap{"other": []byte("keep")}
+	feed.Load(values, 1, 1)
+	feed.Load(values, 2, 1)
+	if string(values["user"]) != "u2" || string(values["pass"]) != "p,2" || string(values["other"]) != "keep" {
+		t.Errorf("Wrong values: %s", values)
+	}
+
+	if res := feedUsers(t, feed, 1, 3); len(res) != 3 || res[0] != "u3" || res[1] != "u1" {
+		t.Errorf("Rows should be reused: %v", res)
+	}
+
+	feed, _ = NewCSVFeed(CSVFeedConf{File: fname, StopOnEOF: true}, 0)
+	if res := feedUsers(t, feed, 1, 5); len(res) != 3 {
+		t.Errorf("Should stop on EOF: %v", res)
+	}
+}
+
+func TestCSVFeedSharded(t *testing.T) {
+	fname := writeCSV(t, "u1;1\nu2;2\nu3;3\nu4;4\nu5;5\n")
+	conf := CSVFeedConf{File: fname, Columns: []string{"user", "id"}, Delimiter: ";", Mode: CSVFeedSharded, StopOnEOF: true}
+	feed, err := NewCSVFeed(conf, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res := feedUsers(t, feed, 1, 5); len(res) != 3 || res[0] != "u1" || res[1] != "u3" || res[2] != "u5" {
+		t.Errorf("Wrong rows for worker 1: %v", res)
+	}
+
+	if res := feedUsers(t, feed, 2, 5); len(res) != 2 || res[0] != "u2" || res[1] != "u4" {
+		t.Errorf("Wrong rows for worker 2: %v", res)
+	}
+
+	conf.StopOnEOF = false
+	feed, _ = NewCSVFeed(conf, 2)
+	if res := feedUsers(t, feed, 2, 3); len(res) != 3 || res[2] != "u2" {
+		t.Errorf("Rows should be reused: %v", res)
+	}
+
+	if _, err := NewCSVFeed(conf, 0); err == nil {
+		t.Errorf("Sharded mode needs workers limit")
+	}
+}
+
+func TestCSVFeedPerWorker(t *testing.T) {
+	fname := writeCSV(t, "user\nu1\nu2\n")
+	feed, err := NewCSVFeed(CSVFeedConf{File: fname, PerWorker: true, Mode: CSVFeedRandom}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := feedUsers(t, feed, 1, 10)
+	for _, user := range res {
+		if user != res[0] {
+			t.Errorf("Row should be the same for all iterations: %v", res)
+		}
+	}
+
+	if _, err := NewCSVFeed(CSVFeedConf{File: fname, Mode: "unknown"}, 0); err == nil {
+		t.Errorf("Should fail on unknown mode")
+	}
+
+	if _, err := NewCSVFeed(CSVFeedConf{File: writeCSV(t, "user\n")}, 0); err == nil {
+		t.Errorf("Should fail on no rows")
+	}
+}
+
+func TestWorkerCSVFeed(t *testing.T) {
+	fname := writeCSV(t, "user\nu1\nu2\n")
+	input := make(InputChannel, 3)
+	for i := 0; i < 3; i++ {
+		input <- &PayloadItem{Payload: []byte("user=${user}"), Replaces: []string{"user"}, StrIndex: &StrIndex{}}
+	}
+
+	wconf := WorkerConf{Mode: WorkloadClosed, WorkloadSchedule: []WorkloadLevel{{LevelStart: 0, LevelEnd: 1}}}
+	iconf := InputConf{Predefined: input, CSVFeeds: []CSVFeedConf{{File: fname, StopOnEOF: true}}}
+	wl := NewBaseWorkload(func() Nib { return DummyNib{} }, NewOutput(OutputConf{}), iconf, wconf, NewStatus())
+
+	sched := make(ScheduleChannel, 3)
+	w := NewBasicWorker(1, make(chan struct{}), wl, sched, wl.Values)
+	for i := 0; i < 3; i++ {
+		sched <- 0
+	}
+
+	if w.Iteration() || string(w.Values["user"]) != "u1" {
+		t.Errorf("Wrong first iteration: %s", w.Values)
+	}
+
+	if w.Iteration() || string(w.Values["user"]) != "u2" {
+		t.Errorf("Wrong second iteration: %s", w.Values)
+	}
+
+	if !w.Iteration() {
+		t.Errorf("Worker should stop on exhausted feed")
+	}
+}
@@ -0,0 +1,121 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Extractor kinds, regex is the default
+const (
+	ExtractRegexKind    = "regex"
+	ExtractJSONPathKind = "jsonpath"
+	ExtractHeaderKind   = "header"
+	ExtractStatusKind   = "status"
+	ExtractCookieKind   = "cookie"
+)
+
+// prepare checks the extractor and compiles its expression
+func (r *ExtractRegex) prepare() error {
+	switch r.Kind {
+	case "", ExtractRegexKind:
+		if r.Re == nil || r.Re.Regexp == nil {
+			return errors.New("Regex extractor needs 're'")
+		}
+	case ExtractJSONPathKind:
+		path, err := CompileJSONPath(r.Expr)
+		if err != nil {
+			return err
+		}
+		r.path = path
+	case ExtractHeaderKind, ExtractCookieKind:
+		if r.Expr == "" {
+			return errors.New(fmt.Sprintf("Extractor of kind '%s' needs name in 'expr'", r.Kind))
+		}
+	case ExtractStatusKind:
+	default:
+		return errors.New(fmt.Sprintf("Unknown extractor kind: %s", r.Kind))
+	}
+	return nil
+}
+
+// parsedResponse is response split into parts for structured extractors, data without HTTP head is all body
+type parsedResponse struct {
+	headers [][]byte
+	body    []byte
+	doc     interface{}
+	parsed  bool
+	docErr  error
+}
+
+func parseResponse(data []byte) *parsedResponse {
+	resp := &parsedResponse{body: data}
+	if !bytes.HasPrefix(data, []byte("HTTP/")) {
+		return resp
+	}
+
+	head, body, found := bytes.Cut(data, []byte("\r\n\r\n"))
+	if !found {
+		head, body, _ = bytes.Cut(data, []byte("\n\n"))
+	}
+	resp.body = body
+
+	lines := bytes.Split(head, []byte{10})
+	for _, line := range lines[1:] { // skip status line
+		resp.headers = append(resp.headers, bytes.TrimRight(line, "\r"))
+	}
+	return resp
+}
+
+// headerValues gives values of all headers with the name, in order of appearance
+func (p *parsedResponse) headerValues(name string) [][]byte {
+	res := make([][]byte, 0)
+	for _, line := range p.headers {
+		hname, hval, found := bytes.Cut(line, []byte{':'})
+		if found && strings.EqualFold(string(bytes.TrimSpace(hname)), name) {
+			res = append(res, bytes.TrimSpace(hval))
+		}
+	}
+	return res
+}
+
+func (p *parsedResponse) cookieValues(name string) [][]byte {
+	res := make([][]byte, 0)
+	for _, hval := range p.headerValues("Set-Cookie") {
+		pair, _, _ := bytes.Cut(hval, []byte{';'})
+		cname, cval, found := bytes.Cut(pair, []byte{'='})
+		if found && string(bytes.TrimSpace(cname)) == name {
+			res = append(res, bytes.Trim(bytes.TrimSpace(cval), `"`))
+		}
+	}
+	return res
+}
+
+func (p *parsedResponse) jsonValues(path *JSONPath) [][]byte {
+	if !p.parsed {
+		p.doc, p.docErr = parseJSON(p.body)
+		p.parsed = true
+	}
+
+	if p.docErr != nil {
+		return nil
+	}
+	return path.Find(p.doc)
+}
+
+// candidates gives all values found by structured extractor
+func (r *ExtractRegex) candidates(resp *parsedResponse, status uint16) [][]byte {
+	switch r.Kind {
+	case ExtractJSONPathKind:
+		return resp.jsonValues(r.path)
+	case ExtractHeaderKind:
+		return resp.headerValues(r.Expr)
+	case ExtractCookieKind:
+		return resp.cookieValues(r.Expr)
+	case ExtractStatusKind:
+		return [][]byte{[]byte(strconv.Itoa(int(status)))}
+	}
+	return nil
+}
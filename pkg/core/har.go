@@ -0,0 +1,175 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HAR 1.2 archive, only the parts needed to replay requests
+type harArchive struct {
+	Log struct {
+		Pages []struct {
+			ID    string `json:"id"`
+			Title string `json:"title"`
+		} `json:"pages"`
+		Entries []harEntry `json:"entries"`
+	} `json:"log"`
+}
+
+type harEntry struct {
+	PageRef         string    `json:"pageref"`
+	StartedDateTime time.Time `json:"startedDateTime"`
+	Time            float64   `json:"time"` // total milliseconds
+	Request         struct {
+		Method   string         `json:"method"`
+		URL      string         `json:"url"`
+		Headers  []harNameValue `json:"headers"`
+		PostData *struct {
+			MimeType string         `json:"mimeType"`
+			Text     string         `json:"text"`
+			Params   []harNameValue `json:"params"`
+		} `json:"postData"`
+	} `json:"request"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// newHARCache converts archive entries into payload records, entries that can't be replayed are skipped
+func newHARCache(fname string, thinkTime bool) *payloadCache {
+	log.Infof("Reading HAR input file: %s", fname)
+	input, err := openInput(fname)
+	if err != nil {
+		panic(err)
+	}
+
+	data, err := io.ReadAll(input)
+	_ = input.Close()
+	if err != nil {
+		panic(err)
+	}
+
+	archive := harArchive{}
+	err = json.Unmarshal(data, &archive)
+	if err != nil {
+		panic(errors.New(fmt.Sprintf("Failed to parse HAR file %s: %s", fname, err)))
+	}
+
+	titles := map[string]string{}
+	for _, page := range archive.Log.Pages {
+		titles[page.ID] = page.Title
+	}
+
+	cache := &payloadCache{items: make([]*PayloadItem, 0)}
+	var prevEnd time.Time
+	for n, entry := range archive.Log.Entries {
+		item, err := harItem(&entry)
+		if err != nil {
+			log.Warningf("Skipping HAR entry #%d: %s", n, err)
+			continue
+		}
+
+		if title := titles[entry.PageRef]; title != "" {
+			item.Label = title
+		}
+
+		if thinkTime && !prevEnd.IsZero() && entry.StartedDateTime.After(prevEnd) {
+			item.ThinkTime = entry.StartedDateTime.Sub(prevEnd)
+		}
+		prevEnd = entry.StartedDateTime.Add(time.Duration(entry.Time * float64(time.Millisecond)))
+
+		cache.items = append(cache.items, item)
+	}
+
+	if len(cache.items) == 0 {
+		panic(fmt.Sprintf("HAR file has no requests to replay: %s", fname))
+	}
+
+	log.Infof("Loaded %d requests from HAR file", len(cache.items))
+	return cache
+}
+
+// harItem builds raw HTTP/1.1 request, recorded HTTP/2 pseudo-headers are replaced with Host header
+func harItem(entry *harEntry) (*PayloadItem, error) {
+	req := entry.Request
+	parsed, err := url.Parse(req.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return nil, errors.New(fmt.Sprintf("Unsupported URL scheme: %s", req.URL))
+	}
+
+	body := ""
+	contentType := ""
+	if req.PostData != nil {
+		body = req.PostData.Text
+		contentType = req.PostData.MimeType
+		if body == "" && len(req.PostData.Params) > 0 {
+			params := make([]string, 0, len(req.PostData.Params))
+			for _, p := range req.PostData.Params {
+				params = append(params, url.QueryEscape(p.Name)+"="+url.QueryEscape(p.Value))
+			}
+			body = strings.Join(params, "&")
+		}
+	}
+
+	buf := bytes.Buffer{}
+	buf.WriteString(req.Method + " " + parsed.RequestURI() + " HTTP/1.1\r\n")
+
+	hasHost := false
+	hasType := false
+	for _, h := range req.Headers {
+		name := strings.ToLower(h.Name)
+		switch {
+		case strings.HasPrefix(name, ":"), name == "content-length", name == "transfer-encoding":
+			continue
+		case name == "host":
+			hasHost = true
+		case name == "content-type":
+			hasType = true
+		}
+		buf.WriteString(h.Name + ": " + h.Value + "\r\n")
+	}
+
+	if !hasHost {
+		buf.WriteString("Host: " + parsed.Host + "\r\n")
+	}
+
+	if !hasType && contentType != "" && body != "" {
+		buf.WriteString("Content-Type: " + contentType + "\r\n")
+	}
+
+	if body != "" || req.Method == "POST" || req.Method == "PUT" || req.Method == "PATCH" {
+		buf.WriteString("Content-Length: " + strconv.Itoa(len(body)) + "\r\n")
+	}
+	buf.WriteString("\r\n")
+	buf.WriteString(body)
+
+	item := &PayloadItem{
+		Label:    parsed.Scheme + "://" + parsed.Host + parsed.Path,
+		Address:  parsed.Scheme + "://" + parsed.Host,
+		Payload:  buf.Bytes(),
+		RegexOut: map[string]*ExtractRegex{},
+	}
+	item.PayloadLen = len(item.Payload)
+	return item, nil
+}
+
+func harInput(config InputConf) InputChannel {
+	cache := getPayloadCache(config.PayloadFile, func() *payloadCache {
+		return newHARCache(config.PayloadFile, config.ThinkTime)
+	})
+	return cache.loop(config)
+}
@@ -0,0 +1,72 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testHAR = `{"log": {
+  "version": "1.2",
+  "pages": [{"id": "page_1", "title": "Home page", "startedDateTime": "2023-01-10T10:00:00.000Z"}],
+  "entries": [
+    {"pageref": "page_1", "startedDateTime": "2023-01-10T10:00:00.000Z", "time": 150,
+     "request": {"method": "GET", "url": "https://example.com/index.html?q=1#top", "httpVersion": "HTTP/2",
+       "headers": [{"name": ":authority", "value": "example.com"}, {"name": ":method", "value": "GET"},
+                   {"name": "accept", "value": "text/html"}]}},
+    {"startedDateTime": "2023-01-10T10:00:00.100Z", "time": 50,
+     "request": {"method": "GET", "url": "wss://example.com/socket", "headers": []}},
+    {"startedDateTime": "2023-01-10T10:00:02.150Z", "time": 20,
+     "request": {"method": "POST", "url": "http://example.com:8080/login", "httpVersion": "HTTP/1.1",
+       "headers": [{"name": "Host", "value": "example.com:8080"}, {"name": "Content-Length", "value": "999"}],
+       "postData": {"mimeType": "application/x-www-form-urlencoded",
+         "params": [{"name": "user", "value": "a b"}, {"name": "pass", "value": "&"}]}}},
+    {"startedDateTime": "2023-01-10T10:00:02.160Z", "time": 20,
+     "request": {"method": "PUT", "url": "http://example.com:8080/data",
+       "headers": [{"name": "Content-Type", "value": "application/json"}],
+       "postData": {"mimeType": "application/json", "text": "{\"a\": 1}"}}}
+  ]
+}}`
+
+func TestHARInput(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "session.har")
+	if err := os.WriteFile(fname, []byte(testHAR), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	items := make([]*PayloadItem, 0)
+	for item := range NewInput(InputConf{PayloadFile: fname, Format: InputFormatHAR, ThinkTime: true, IterationLimit: 2}) {
+		items = append(items, item)
+	}
+
+	if len(items) != 6 {
+		t.Fatalf("Wrong items count: %d", len(items))
+	}
+
+	expected := []struct {
+		label   string
+		address string
+		payload string
+		think   time.Duration
+	}{
+		{"Home page", "https://example.com",
+			"GET /index.html?q=1 HTTP/1.1\r\naccept: text/html\r\nHost: example.com\r\n\r\n", 0},
+		{"http://example.com:8080/login", "http://example.com:8080",
+			"POST /login HTTP/1.1\r\nHost: example.com:8080\r\nContent-Type: application/x-www-form-urlencoded\r\n" +
+				"Content-Length: 17\r\n\r\nuser=a+b&pass=%26", 2 * time.Second},
+		{"http://example.com:8080/data", "http://example.com:8080",
+			"PUT /data HTTP/1.1\r\nContent-Type: application/json\r\nHost: example.com:8080\r\nContent-Length: 8\r\n\r\n{\"a\": 1}", 0},
+	}
+
+	for n, item := range items {
+		exp := expected[n%3]
+		if item.Label != exp.label || item.Address != exp.address || item.ThinkTime != exp.think {
+			t.Errorf("Wrong item %d: %s, %s, %v", n, item.Label, item.Address, item.ThinkTime)
+		}
+
+		if string(item.Payload) != exp.payload || item.PayloadLen != len(exp.payload) {
+			t.Errorf("Wrong payload %d: %q", n, item.Payload)
+		}
+	}
+}
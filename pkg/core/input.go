@@ -1,6 +1,7 @@
 package core
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"errors"
@@ -17,12 +18,28 @@ import (
 // input file can contain timestamps, or can rely on internal schedule calculator
 // internal schedule calculator: warmup, ramp-up, steps, constant; for workers and for rps
 
+// Payload file formats, native is the default
+const (
+	InputFormatNative    = "native"
+	InputFormatHAR       = "har"
+	InputFormatAccessLog = "accesslog"
+)
+
 type InputConf struct {
 	PayloadFile    string
+	Format         string             // native, har or accesslog
+	ThinkTime      bool               // keep pauses between requests recorded in HAR file
+	AccessLog      AccessLogConf      // parsing options for access log
+	Speed          float64            // replay speed multiplier for recorded timestamps, 1 by default
+	Order          string             // sequential, weighted or shuffle
+	Seed           int64              // random seed for weighted and shuffle orders, time-based if zero
+	LabelWeights   map[string]float64 // weights shared by records with the label, for weighted order
 	StringsFile    string
 	EnableRegexes  bool
-	Predefined     InputChannel `yaml:"-"`
+	CacheInMemory  bool         // read payload file once and serve records from memory
+	Predefined     InputChannel `yaml:"-"` // items sent into it belong to the caller, they are not returned to pool after use
 	IterationLimit int
+	CSVFeeds       []CSVFeedConf // CSV files with rows loaded into worker values
 }
 
 type InputChannel chan *PayloadItem
@@ -48,30 +65,43 @@ type PayloadItem struct {
 	AssertsIdx []uint16      `json:"c"`
 	Asserts    []*AssertItem `json:"asserts"`
 
+	Offset float64  `json:"offset"` // milliseconds since test start, for schedule taken from input
+	Weight *float64 `json:"weight"` // relative frequency for weighted input order, 1 if omitted, zero is never picked
+
 	StrIndex *StrIndex `json:"-"`
+
+	SourceFile   *os.File      `json:"-"` // payload file to send unchanged payload from, when it is cached in memory
+	SourceOffset int64         `json:"-"`
+	ThinkTime    time.Duration `json:"-"` // pause before sending, counted from the scheduled time
+
+	borrowed bool // payload refers to cached file contents
+	pooled   bool // taken from pool, so it goes back there on release
 }
 
 var regexCache = map[string]*regexp.Regexp{}
 
 func (i *PayloadItem) ReplaceValues(values ValMap) {
+	i.ReplaceValuesFor(values, nil)
+}
+
+// ReplaceValuesFor substitutes variables and template functions listed in Replaces, each evaluated once per item
+func (i *PayloadItem) ReplaceValuesFor(values ValMap, ctx *FuncContext) {
+	if len(i.Replaces) > 0 { // payload is a new slice after replacing, not the one from file
+		i.SourceFile = nil
+	}
+
 	for _, name := range i.Replaces {
 		i.ResolveStrings()
 
-		val, ok := values[name]
+		val, ok := evalTemplate(name, values, ctx)
 		if !ok {
-			val = []byte("NO_VALUE") // TODO: document that it works like that
+			continue
 		}
 
-		var re *regexp.Regexp
-		if r, ok := regexCache[name]; ok {
-			re = r
-		} else {
-			re = regexp.MustCompile(`(?m:\$\{` + name + "})")
-			regexCache[name] = re
-		}
-		i.Payload = re.ReplaceAll(i.Payload, val)
-		i.Label = re.ReplaceAllString(i.Label, string(val))
-		i.Address = re.ReplaceAllString(i.Address, string(val))
+		token := "${" + name + "}"
+		i.Payload = bytes.ReplaceAll(i.Payload, []byte(token), val)
+		i.Label = strings.ReplaceAll(i.Label, token, string(val))
+		i.Address = strings.ReplaceAll(i.Address, token, string(val))
 	}
 }
 
@@ -111,18 +141,75 @@ func (r *RegexpProxy) MarshalText() ([]byte, error) {
 }
 
 type ExtractRegex struct {
+	Kind    string       `json:"kind"` // regex by default, or one of jsonpath, header, status and cookie
 	Re      *RegexpProxy `json:"re"`
+	Expr    string       `json:"expr"`    // JSONPath, header or cookie name for structured extractors
 	GroupNo uint         `json:"groupNo"` // group 0 means whole match that were found
 	MatchNo int          `json:"matchNo"` // -1 means random
+
+	path *JSONPath
 }
 
 func (r *ExtractRegex) String() string {
+	if r.Kind != "" && r.Kind != ExtractRegexKind {
+		return r.Kind + " " + r.Expr + " match " + strconv.Itoa(r.MatchNo)
+	}
 	return r.Re.String() + " group " + strconv.Itoa(int(r.GroupNo)) + " match " + strconv.Itoa(r.MatchNo)
 }
 
-type AssertItem struct {
-	Re     *RegexpProxy
-	Invert bool
+// NewInputSchedule reads input records along with their offsets as schedule, each loop over input goes after the previous one.
+// Offset of each record is rewritten to its scheduled time, so the worker that takes it can start it right on time.
+func NewInputSchedule(config InputConf) (InputChannel, ScheduleChannel) {
+	if config.Predefined != nil {
+		panic("Schedule can't be taken from predefined input")
+	} else if config.Order != "" && config.Order != InputOrderSequential {
+		panic(fmt.Sprintf("Schedule can't be taken from input in %s order", config.Order))
+	}
+
+	speed := config.Speed
+	if speed <= 0 {
+		speed = 1
+	}
+
+	loopConf := config
+	loopConf.IterationLimit = 1
+
+	items := make(InputChannel)
+	ch := make(ScheduleChannel)
+	go func() {
+		iterations := 0
+		base := time.Duration(0)
+		last := time.Duration(0)
+		warned := false
+		for {
+			cnt := 0
+			for item := range NewInput(loopConf) {
+				offset := base + time.Duration(item.Offset*float64(time.Millisecond)/speed)
+				if offset < last { // like access log lines written on completion
+					if !warned {
+						log.Warningf("Input record offsets go backwards, sending them without delay: %v < %v", offset, last)
+						warned = true
+					}
+					offset = last
+				}
+				last = offset
+				item.Offset = float64(offset) / float64(time.Millisecond)
+
+				ch <- offset
+				items <- item
+				cnt++
+			}
+
+			base = last
+			iterations++
+			if cnt == 0 || readOnce(config) || config.IterationLimit > 0 && iterations >= config.IterationLimit {
+				break
+			}
+		}
+		close(ch)
+		close(items)
+	}()
+	return items, ch
 }
 
 func NewInput(config InputConf) InputChannel {
@@ -130,10 +217,14 @@ func NewInput(config InputConf) InputChannel {
 		return config.Predefined
 	}
 
-	log.Infof("Opening payload input file: %s", config.PayloadFile)
-	file, err := os.Open(config.PayloadFile)
-	if err != nil {
-		panic(err)
+	switch config.Format {
+	case "", InputFormatNative:
+	case InputFormatHAR:
+		return harInput(config)
+	case InputFormatAccessLog:
+		return accessLogInput(config)
+	default:
+		panic(fmt.Sprintf("Unsupported input format: %s", config.Format))
 	}
 
 	var strIndex *StrIndex
@@ -141,41 +232,53 @@ func NewInput(config InputConf) InputChannel {
 		strIndex = NewStringIndex(config.StringsFile, true)
 	}
 
+	if inputCached(config) {
+		return cachedInput(config, strIndex)
+	}
+
+	rewindable := inputRewindable(config.PayloadFile)
+	if !rewindable && config.IterationLimit != 1 {
+		log.Warningf("Payload input %s is a stream, it will be read once without looping", config.PayloadFile)
+	}
+
 	ch := make(InputChannel)
 	go func() {
 		iterations := 0
 		good := 0
 		bad := 0
-		buf := make([]byte, 4096)
 		for {
-			offset, _ := file.Seek(0, io.SeekCurrent)
-			item, err := readPayloadRecord(file, buf, strIndex)
-			if err == io.EOF {
-				iterations++
-				if config.IterationLimit > 0 && iterations >= config.IterationLimit {
+			log.Infof("Opening payload input file: %s", config.PayloadFile)
+			file, err := openInput(config.PayloadFile)
+			if err != nil {
+				panic(err)
+			}
+
+			reader := bufio.NewReaderSize(file, inputBufferSize)
+			for n := 0; ; n++ {
+				item, err := readPayloadRecord(reader, strIndex)
+				if err == io.EOF {
 					break
+				} else if err != nil {
+					log.Errorf("Failed to read payload record #%d: %s", n, err)
+					bad++
+					continue
 				}
 
-				ratio := float64(good) / float64(good+bad)
-				if ratio < 0.5 {
-					panic(fmt.Sprintf("Payload input file is problematic: %d good records and %d bad records read", good, bad))
-				}
+				good++
+				ch <- item
+			}
+			_ = file.Close()
 
-				log.Debugf("Rewind payload file")
-				_, err = file.Seek(0, 0)
-				if err != nil {
-					log.Errorf("Failed to rewind the input file")
-					panic(err)
-				}
-				continue
-			} else if err != nil {
-				log.Errorf("Failed to read payload record at offset %d: %s", offset, err)
-				bad++
-				continue
+			iterations++
+			if !rewindable || config.IterationLimit > 0 && iterations >= config.IterationLimit {
+				break
 			}
 
-			good++
-			ch <- item
+			ratio := float64(good) / float64(good+bad)
+			if ratio < 0.5 {
+				panic(fmt.Sprintf("Payload input file is problematic: %d good records and %d bad records read", good, bad))
+			}
+			log.Debugf("Rewind payload file")
 		}
 		log.Infof("Input exhausted")
 		close(ch)
@@ -183,31 +286,37 @@ func NewInput(config InputConf) InputChannel {
 	return ch
 }
 
-func readPayloadRecord(file io.ReadSeeker, buf []byte, index *StrIndex) (*PayloadItem, error) {
-	// read buf that hopefully contains meta info
-	nread, err := file.Read(buf)
-	if err != nil {
-		return nil, err
-	}
+// inputBufferSize is also the limit for metadata line length
+const inputBufferSize = 64 * 1024
+
+func readPayloadRecord(reader *bufio.Reader, index *StrIndex) (*PayloadItem, error) {
+	return readRecord(reader, index, readPayload)
+}
+
+type payloadReader = func(reader *bufio.Reader, item *PayloadItem, plen int) error
 
+func readRecord(reader *bufio.Reader, index *StrIndex, readPayload payloadReader) (*PayloadItem, error) {
 	// skip inter-record separators
-	offset := 0
-	for offset < nread && (buf[offset] == 10 || buf[offset] == 13) {
-		offset++
-	}
+	for {
+		c, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
 
-	if offset == nread {
-		return nil, io.EOF
+		if c != 10 && c != 13 {
+			_ = reader.UnreadByte()
+			break
+		}
 	}
 
-	meta := readMeta(file, buf, offset, nread)
-
-	item := &PayloadItem{
-		StrIndex: index,
-		RegexOut: map[string]*ExtractRegex{},
-		Replaces: []string{},
-		Asserts:  []*AssertItem{},
+	meta, err := readMeta(reader)
+	if err != nil {
+		return nil, err
 	}
+	log.Debugf("Read record metadata: %s", meta)
+
+	item := NewPayloadItem()
+	item.StrIndex = index
 
 	errMeta := json.Unmarshal(meta, item)
 	plen := item.PayloadLen
@@ -222,12 +331,13 @@ func readPayloadRecord(file io.ReadSeeker, buf []byte, index *StrIndex) (*Payloa
 		}
 	}
 
-	errPayload := readPayload(file, item, plen)
+	errPayload := readPayload(reader, item, plen)
 	if errPayload != nil {
 		log.Warningf("Failed to read payload of len %d: %s", plen, errPayload)
 	}
 
 	if errMeta != nil {
+		item.Release()
 		return nil, errors.New(fmt.Sprintf("Failed to decode metadata: %s", errMeta))
 	} else if errPayload != nil {
 		panic(errPayload)
@@ -237,38 +347,39 @@ func readPayloadRecord(file io.ReadSeeker, buf []byte, index *StrIndex) (*Payloa
 
 	err = decodeExtracts(item)
 	if err != nil {
+		item.Release()
 		return nil, err
 	}
 
 	err = decodeAsserts(item)
 	if err != nil {
+		item.Release()
 		return nil, err
 	}
 
-	log.Debugf("Produced item: %s", meta)
 	return item, nil
 }
 
-func readMeta(file io.ReadSeeker, buf []byte, offset int, nread int) []byte {
-	// read meta
-	meta, rest, found := bytes.Cut(buf[offset:nread], []byte{10})
-	if !found {
-		panic(fmt.Sprintf("Meta information line did not contain the newline within %d bytes buffer: %s", nread, buf[:nread]))
-	}
-
-	// seek payload start
-	_, err := file.Seek(-int64(len(rest)), io.SeekCurrent)
-	if err != nil {
-		log.Warningf("Failed to seek payload start %d", -len(rest))
-		panic(err)
+// readMeta gives metadata line, valid until the next read
+func readMeta(reader *bufio.Reader) ([]byte, error) {
+	meta, err := reader.ReadSlice(10)
+	if err == bufio.ErrBufferFull {
+		panic(fmt.Sprintf("Meta information line did not contain the newline within %d bytes buffer: %s", len(meta), meta))
+	} else if err == io.EOF {
+		return nil, errors.New(fmt.Sprintf("Unexpected end of input after: %s", meta))
+	} else if err != nil {
+		return nil, err
 	}
-	return meta
+	return meta[:len(meta)-1], nil
 }
 
-func readPayload(file io.ReadSeeker, item *PayloadItem, plen int) error {
+func readPayload(reader *bufio.Reader, item *PayloadItem, plen int) error {
 	// read payload
-	item.Payload = make([]byte, plen)
-	_, err := io.ReadFull(file, item.Payload)
+	if cap(item.Payload) < plen {
+		item.Payload = make([]byte, plen)
+	}
+	item.Payload = item.Payload[:plen]
+	_, err := io.ReadFull(reader, item.Payload)
 	return err
 }
 
@@ -276,12 +387,22 @@ func decodeReplaces(item *PayloadItem) {
 	for _, idx := range item.ReplacesIdx {
 		item.Replaces = append(item.Replaces, item.StrIndex.Get(idx))
 	}
-	item.ReplacesIdx = []uint16{}
+	item.ReplacesIdx = item.ReplacesIdx[:0]
 }
 
+// decodeAsserts reads "invert regex" strings, other assertion kinds are JSON objects, as their expression may contain spaces
 func decodeAsserts(item *PayloadItem) error {
 	for _, idx := range item.AssertsIdx {
 		s := item.StrIndex.Get(idx)
+		if strings.HasPrefix(s, "{") {
+			a := &AssertItem{}
+			if err := json.Unmarshal([]byte(s), a); err != nil {
+				return errors.New(fmt.Sprintf("Failed to decode assertion %s: %s", s, err))
+			}
+			item.Asserts = append(item.Asserts, a)
+			continue
+		}
+
 		invert, sre, _ := strings.Cut(s, " ")
 
 		var re = &RegexpProxy{}
@@ -298,16 +419,34 @@ func decodeAsserts(item *PayloadItem) error {
 
 		item.Asserts = append(item.Asserts, &AssertItem{Invert: invert != "0", Re: re})
 	}
-	item.AssertsIdx = []uint16{}
+	item.AssertsIdx = item.AssertsIdx[:0]
+
+	for n, a := range item.Asserts {
+		if err := a.prepare(); err != nil {
+			return errors.New(fmt.Sprintf("Invalid assertion #%d: %s", n, err))
+		}
+	}
 	return nil
 }
 
+// decodeExtracts reads "name match group regex" strings, or "name match kind expr" for structured extractors
 func decodeExtracts(item *PayloadItem) error {
 	for _, idx := range item.RegexOutIdx {
 		s := item.StrIndex.Get(idx)
 		name, s, _ := strings.Cut(s, " ")
 		match, s, _ := strings.Cut(s, " ")
 		group, sre, _ := strings.Cut(s, " ")
+		m, _ := strconv.Atoi(match)
+
+		g, err := strconv.Atoi(group)
+		if err != nil {
+			item.RegexOut[name] = &ExtractRegex{
+				Kind:    group,
+				Expr:    sre,
+				MatchNo: m,
+			}
+			continue
+		}
 
 		var re = &RegexpProxy{}
 		if r, ok := regexCache[sre]; ok {
@@ -321,16 +460,19 @@ func decodeExtracts(item *PayloadItem) error {
 			regexCache[sre] = re.Regexp
 		}
 
-		g, _ := strconv.Atoi(group)
-		m, _ := strconv.Atoi(match)
-
 		item.RegexOut[name] = &ExtractRegex{
 			Re:      re,
 			GroupNo: uint(g),
 			MatchNo: m,
 		}
 	}
-	item.RegexOutIdx = []uint16{}
+	item.RegexOutIdx = item.RegexOutIdx[:0]
+
+	for name, extractor := range item.RegexOut {
+		if err := extractor.prepare(); err != nil {
+			return errors.New(fmt.Sprintf("Invalid extractor '%s': %s", name, err))
+		}
+	}
 	return nil
 }
 
@@ -1,7 +1,11 @@
 package core
 
 import (
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestReplaceValues(t *testing.T) {
@@ -74,3 +78,107 @@ func TestReadPayloadRecordIndexes(t *testing.T) {
 		t.Errorf("Wrong regex read for assertion")
 	}
 }
+
+func TestDecodeExtracts(t *testing.T) {
+	index := NewStringIndex("", false)
+	item := PayloadItem{
+		StrIndex: index,
+		RegexOut: map[string]*ExtractRegex{
+			"fromjson": {Kind: ExtractCookieKind, Expr: "sid"},
+		},
+		RegexOutIdx: []uint16{index.Idx("re 0 1 id=(\\d+)"), index.Idx("token -1 jsonpath $.data.token"), index.Idx("code 0 status ")},
+	}
+
+	if err := decodeExtracts(&item); err != nil {
+		t.Fatal(err)
+	}
+
+	re, path, status := item.RegexOut["re"], item.RegexOut["token"], item.RegexOut["code"]
+	if re.Re.String() != "id=(\\d+)" || re.GroupNo != 1 || path.Kind != ExtractJSONPathKind || path.path.String() != "$.data.token" ||
+		path.MatchNo != -1 || status.Kind != ExtractStatusKind || len(item.RegexOut) != 4 {
+		t.Errorf("Wrong extractors decoded: %v", item.RegexOut)
+	}
+
+	item.RegexOutIdx = []uint16{index.Idx("bad 0 xpath //a")}
+	if err := decodeExtracts(&item); err == nil || err.Error() != "Invalid extractor 'bad': Unknown extractor kind: xpath" {
+		t.Errorf("Should fail on unknown kind: %v", err)
+	}
+}
+
+func TestDecodeAsserts(t *testing.T) {
+	index := NewStringIndex("", false)
+	item := PayloadItem{
+		StrIndex: index,
+		Asserts:  []*AssertItem{{Kind: AssertStatusKind, Expr: "200"}},
+		AssertsIdx: []uint16{
+			index.Idx("1 \\d+"),
+			index.Idx(`{"kind": "header", "expr": "Content-Type", "value": "application/json; charset=utf-8"}`),
+			index.Idx(`{"kind": "jsonpath", "expr": "$['error text']", "invert": true}`),
+		},
+	}
+
+	if err := decodeAsserts(&item); err != nil {
+		t.Fatal(err)
+	}
+
+	re, header, path := item.Asserts[1], item.Asserts[2], item.Asserts[3]
+	if re.Re.String() != "\\d+" || !re.Invert || header.Kind != AssertHeaderKind || header.Expr != "Content-Type" ||
+		*header.Value != "application/json; charset=utf-8" || header.Invert || path.Expr != "$['error text']" ||
+		path.Value != nil || !path.Invert ||
+		item.Asserts[0].ranges[0][1] != 200 {
+		t.Errorf("Wrong assertions decoded: %v", item.Asserts)
+	}
+
+	item.AssertsIdx = []uint16{index.Idx(`{"kind": "elapsed", "expr": "soon"}`)}
+	if err := decodeAsserts(&item); err == nil {
+		t.Errorf("Should fail on bad duration")
+	}
+}
+
+func TestInputSchedule(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "payload.txt")
+	data := `{"plen": 4, "offset": 0}
+GET
+{"plen": 4, "offset": 1500.5}
+GET
+{"plen": 4, "offset": 1000}
+GET
+{"plen": 4, "offset": 4000}
+GET
+`
+	if err := os.WriteFile(fname, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, cached := range []bool{false, true} {
+		offsets := make([]time.Duration, 0)
+		items, schedule := NewInputSchedule(InputConf{PayloadFile: fname, IterationLimit: 2, Speed: 0.5, CacheInMemory: cached})
+		for offset := range schedule {
+			offsets = append(offsets, offset)
+			item := <-items
+			if time.Duration(item.Offset*float64(time.Millisecond)) != offset {
+				t.Errorf("Item should carry its scheduled offset: %v != %v", item.Offset, offset)
+			}
+			item.Release()
+		}
+
+		if _, ok := <-items; ok {
+			t.Errorf("Items should end with schedule")
+		}
+
+		ms := time.Millisecond
+		exp := []time.Duration{0, 3001 * ms, 3001 * ms, 8000 * ms, 8000 * ms, 11001 * ms, 11001 * ms, 16000 * ms}
+		if !reflect.DeepEqual(offsets, exp) {
+			t.Errorf("Wrong offsets: %v", offsets)
+		}
+	}
+}
+
+func TestInputScheduleOrder(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Shuffled input should not give schedule")
+		}
+	}()
+	NewInputSchedule(InputConf{PayloadFile: "payload.txt", CacheInMemory: true, Order: InputOrderShuffle})
+}
@@ -0,0 +1,194 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// JSONPath is the subset of JSONPath expressions: $, .name, ['name'], [index], [*], .* and .. recursive descent
+type JSONPath struct {
+	expr  string
+	steps []jsonPathStep
+}
+
+type jsonPathStep struct {
+	recursive bool
+	wildcard  bool
+	name      string
+	index     int
+	isIndex   bool
+}
+
+var jsonPathCache = map[string]*JSONPath{}
+var jsonPathCacheMx sync.Mutex
+
+func CompileJSONPath(expr string) (*JSONPath, error) {
+	jsonPathCacheMx.Lock()
+	defer jsonPathCacheMx.Unlock()
+	if p, ok := jsonPathCache[expr]; ok {
+		return p, nil
+	}
+
+	path := &JSONPath{expr: expr, steps: make([]jsonPathStep, 0)}
+	s := strings.TrimSpace(expr)
+	s = strings.TrimPrefix(s, "$")
+	for s != "" {
+		step := jsonPathStep{}
+		switch {
+		case strings.HasPrefix(s, ".."):
+			step.recursive = true
+			s = s[2:]
+		case s[0] == '.':
+			s = s[1:]
+		case s[0] != '[':
+			return nil, errors.New(fmt.Sprintf("Unexpected '%c' in JSONPath: %s", s[0], expr))
+		}
+
+		if strings.HasPrefix(s, "[") {
+			end := strings.IndexByte(s, ']')
+			if end < 0 {
+				return nil, errors.New(fmt.Sprintf("Unclosed bracket in JSONPath: %s", expr))
+			}
+			sel := strings.TrimSpace(s[1:end])
+			s = s[end+1:]
+
+			if sel == "*" {
+				step.wildcard = true
+			} else if len(sel) >= 2 && (sel[0] == '\'' || sel[0] == '"') && sel[len(sel)-1] == sel[0] {
+				step.name = sel[1 : len(sel)-1]
+			} else if idx, err := strconv.Atoi(sel); err == nil {
+				step.index = idx
+				step.isIndex = true
+			} else {
+				return nil, errors.New(fmt.Sprintf("Unsupported selector '%s' in JSONPath: %s", sel, expr))
+			}
+		} else {
+			end := strings.IndexAny(s, ".[")
+			if end < 0 {
+				end = len(s)
+			}
+			step.name = s[:end]
+			s = s[end:]
+
+			if step.name == "" {
+				return nil, errors.New(fmt.Sprintf("Empty name in JSONPath: %s", expr))
+			} else if step.name == "*" {
+				step.wildcard = true
+			}
+		}
+		path.steps = append(path.steps, step)
+	}
+
+	jsonPathCache[expr] = path
+	return path, nil
+}
+
+func (p *JSONPath) String() string {
+	return p.expr
+}
+
+// Find gives all matched values, strings are unquoted and other values are compact JSON
+func (p *JSONPath) Find(doc interface{}) [][]byte {
+	nodes := []interface{}{doc}
+	for _, step := range p.steps {
+		if step.recursive {
+			all := make([]interface{}, 0)
+			for _, node := range nodes {
+				all = descendants(node, all)
+			}
+			nodes = all
+		}
+
+		next := make([]interface{}, 0)
+		for _, node := range nodes {
+			next = step.apply(node, next)
+		}
+		nodes = next
+	}
+
+	res := make([][]byte, 0, len(nodes))
+	for _, node := range nodes {
+		res = append(res, jsonValueBytes(node))
+	}
+	return res
+}
+
+func (s jsonPathStep) apply(node interface{}, res []interface{}) []interface{} {
+	switch v := node.(type) {
+	case map[string]interface{}:
+		if s.wildcard {
+			keys := make([]string, 0, len(v))
+			for k := range v {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				res = append(res, v[k])
+			}
+		} else if val, ok := v[s.name]; ok && !s.isIndex {
+			res = append(res, val)
+		}
+	case []interface{}:
+		if s.wildcard {
+			res = append(res, v...)
+		} else if s.isIndex {
+			idx := s.index
+			if idx < 0 {
+				idx += len(v)
+			}
+			if idx >= 0 && idx < len(v) {
+				res = append(res, v[idx])
+			}
+		}
+	}
+	return res
+}
+
+func descendants(node interface{}, res []interface{}) []interface{} {
+	res = append(res, node)
+	switch v := node.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			res = descendants(v[k], res)
+		}
+	case []interface{}:
+		for _, item := range v {
+			res = descendants(item, res)
+		}
+	}
+	return res
+}
+
+func jsonValueBytes(val interface{}) []byte {
+	switch v := val.(type) {
+	case string:
+		return []byte(v)
+	case json.Number:
+		return []byte(v)
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil
+		}
+		return data
+	}
+}
+
+func parseJSON(data []byte) (interface{}, error) {
+	var doc interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	err := decoder.Decode(&doc)
+	return doc, err
+}
@@ -0,0 +1,54 @@
+package core
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestJSONPath(t *testing.T) {
+	doc, err := parseJSON([]byte(`{"data": {"token": "abc", "items": [{"id": 1, "name": "x"}, {"id": 2.5, "tags": ["a", "b"]}]}, "ok": true, "none": null, "odd key": "v"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]string{
+		"$.data.token":        "abc",
+		"$['data']['token']":  "abc",
+		"$.data.items[0].id":  "1",
+		"$.data.items[-1].id": "2.5",
+		"$.data.items[*].id":  "1|2.5",
+		"$..id":               "1|2.5",
+		"$..tags[1]":          "b",
+		"$.data.items[1]":     `{"id":2.5,"tags":["a","b"]}`,
+		"$.ok":                "true",
+		"$.none":              "null",
+		"$[\"odd key\"]":      "v",
+		"$.data.*":            `[{"id":1,"name":"x"},{"id":2.5,"tags":["a","b"]}]|abc`,
+		"$.missing":           "",
+		"$.data.items[5]":     "",
+		"$.data.token[0]":     "",
+	}
+
+	for expr, expected := range cases {
+		path, err := CompileJSONPath(expr)
+		if err != nil {
+			t.Errorf("Failed to compile %s: %s", expr, err)
+			continue
+		}
+
+		found := make([]string, 0)
+		for _, val := range path.Find(doc) {
+			found = append(found, string(val))
+		}
+
+		if strings.Join(found, "|") != expected {
+			t.Errorf("Wrong result for %s: %v", expr, found)
+		}
+	}
+
+	for _, expr := range []string{"$.", "$[0", "$[?(@.id)]", "$x"} {
+		if _, err := CompileJSONPath(expr); err == nil {
+			t.Errorf("Should fail to compile %s", expr)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package core
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// Input orders, sequential is the default
+const (
+	InputOrderSequential = "sequential"
+	InputOrderWeighted   = "weighted"
+	InputOrderShuffle    = "shuffle"
+)
+
+// inputCached tells if records are served from memory, which is needed to reorder them
+func inputCached(config InputConf) bool {
+	return config.CacheInMemory || config.Order != "" && config.Order != InputOrderSequential
+}
+
+// recordWeights gives weight of each record, 1 by default, label weight is split evenly between records of the label.
+// Records with zero weight are kept in cache, but never picked.
+func recordWeights(items []*PayloadItem, labelWeights map[string]float64) []float64 {
+	labels := make([]string, len(items))
+	counts := map[string]int{}
+	for n, item := range items {
+		labels[n] = item.Label
+		if labels[n] == "" && item.LabelIdx > 0 {
+			labels[n] = item.StrIndex.Get(item.LabelIdx)
+		}
+		counts[labels[n]]++
+	}
+
+	weights := make([]float64, len(items))
+	for n, item := range items {
+		if lw, ok := labelWeights[labels[n]]; ok {
+			weights[n] = lw / float64(counts[labels[n]])
+		} else if item.Weight != nil {
+			if *item.Weight < 0 {
+				panic(fmt.Sprintf("Input record weight can't be negative: %v", *item.Weight))
+			}
+			weights[n] = *item.Weight
+		} else {
+			weights[n] = 1
+		}
+	}
+
+	for label := range labelWeights {
+		if counts[label] == 0 {
+			log.Warningf("No input records have label with weight: %s", label)
+		}
+	}
+	return weights
+}
+
+// orderer gives function filling indexes of records for the next loop over input
+func (c *payloadCache) orderer(config InputConf) func(idx []int) {
+	seed := config.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	rng := rand.New(rand.NewSource(seed))
+
+	switch config.Order {
+	case "", InputOrderSequential:
+		return func(idx []int) {
+			for n := range idx {
+				idx[n] = n
+			}
+		}
+	case InputOrderShuffle:
+		return func(idx []int) {
+			for n := range idx {
+				idx[n] = n
+			}
+			rng.Shuffle(len(idx), func(i, j int) {
+				idx[i], idx[j] = idx[j], idx[i]
+			})
+		}
+	case InputOrderWeighted:
+		cumulative := recordWeights(c.items, config.LabelWeights)
+		for n := 1; n < len(cumulative); n++ {
+			cumulative[n] += cumulative[n-1]
+		}
+		total := cumulative[len(cumulative)-1]
+		if total <= 0 {
+			panic("Total weight of input records must be positive")
+		}
+
+		return func(idx []int) {
+			for n := range idx {
+				x := rng.Float64() * total
+				idx[n] = sort.Search(len(cumulative), func(i int) bool {
+					return cumulative[i] > x
+				})
+			}
+		}
+	default:
+		panic(fmt.Sprintf("Unsupported input order: %s", config.Order))
+	}
+}
@@ -0,0 +1,103 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+const mixRecords = `{"plen": 4, "label": "read", "weight": 7}
+read
+{"plen": 6, "label": "search", "weight": 2}
+search
+{"plen": 5, "label": "write"}
+write
+{"plen": 4, "label": "read"}
+read
+`
+
+func mixCounts(t *testing.T, conf InputConf) (map[string]int, []string) {
+	counts := map[string]int{}
+	labels := make([]string, 0)
+	for item := range NewInput(conf) {
+		counts[item.Label]++
+		labels = append(labels, item.Label)
+		item.Release()
+	}
+	return counts, labels
+}
+
+func TestWeightedInput(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "mix.txt")
+	if err := os.WriteFile(fname, []byte(mixRecords), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	conf := InputConf{PayloadFile: fname, Order: InputOrderWeighted, Seed: 42, IterationLimit: 2500}
+	counts, _ := mixCounts(t, conf)
+	if counts["read"]+counts["search"]+counts["write"] != 10000 {
+		t.Fatalf("Wrong number of records: %v", counts)
+	}
+
+	// weights are 7+1, 2 and 1
+	if counts["read"] < 7000 || counts["read"] > 7500 || counts["search"] < 1600 || counts["search"] > 2000 || counts["write"] < 750 || counts["write"] > 1050 {
+		t.Errorf("Wrong mix: %v", counts)
+	}
+
+	conf.LabelWeights = map[string]float64{"read": 0, "search": 3}
+	counts, _ = mixCounts(t, conf)
+	if counts["read"] != 0 || counts["search"] < 7250 || counts["search"] > 7750 {
+		t.Errorf("Wrong mix with label weights: %v", counts)
+	}
+}
+
+func TestShuffledInput(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "mix.txt")
+	if err := os.WriteFile(fname, []byte(mixRecords), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	conf := InputConf{PayloadFile: fname, Order: InputOrderShuffle, Seed: 7, IterationLimit: 10}
+	_, labels := mixCounts(t, conf)
+	_, again := mixCounts(t, conf)
+	if !reflect.DeepEqual(labels, again) {
+		t.Errorf("Same seed should give the same order")
+	}
+
+	sorted := make([]string, 0)
+	for n := 0; n < len(labels); n += 4 {
+		loop := append([]string{}, labels[n:n+4]...)
+		sort.Strings(loop)
+		if !reflect.DeepEqual(loop, []string{"read", "read", "search", "write"}) {
+			t.Errorf("Each loop should have all records: %v", loop)
+		}
+		sorted = append(sorted, loop...)
+	}
+
+	if reflect.DeepEqual(labels, sorted) {
+		t.Errorf("Records should be shuffled: %v", labels)
+	}
+}
+
+func TestZeroWeight(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "mix.txt")
+	records := "{\"plen\": 4, \"label\": \"read\", \"weight\": 0}\nread\n{\"plen\": 5, \"label\": \"write\"}\nwrite\n"
+	if err := os.WriteFile(fname, []byte(records), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	counts, _ := mixCounts(t, InputConf{PayloadFile: fname, Order: InputOrderWeighted, Seed: 1, IterationLimit: 500})
+	if counts["read"] != 0 || counts["write"] != 1000 {
+		t.Errorf("Record with zero weight should not be picked: %v", counts)
+	}
+
+	weight := -1.0
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Negative weight should be rejected")
+		}
+	}()
+	recordWeights([]*PayloadItem{{Label: "read", Weight: &weight}}, nil)
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package core
+
+import (
+	"io"
+	"os"
+)
+
+// mapFile reads whole file into memory, where mmap is not available
+func mapFile(file *os.File) ([]byte, error) {
+	return io.ReadAll(file)
+}
@@ -0,0 +1,22 @@
+//go:build unix
+
+package core
+
+import (
+	"golang.org/x/sys/unix"
+	"os"
+)
+
+// mapFile maps whole file into memory read-only, pages are shared by OS page cache
+func mapFile(file *os.File) ([]byte, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if info.Size() == 0 {
+		return []byte{}, nil
+	}
+
+	return unix.Mmap(int(file.Fd()), 0, int(info.Size()), unix.PROT_READ, unix.MAP_SHARED)
+}
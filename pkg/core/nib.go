@@ -13,8 +13,7 @@ type Nib interface {
 type NibMaker = func() Nib
 
 // ipv4/ipv6
-// http and https and dummy (udp? pluggable?)
-// use less memory by direct send from file descriptor into network https://man7.org/linux/man-pages/man2/sendfile.2.html (does not work with SSL)
+// http and https, http2, grpc, websocket, raw tcp and udp, and dummy (pluggable?)
 // multiple hosts allowed, working with connection pools and defaults to one
 // handle HTTP-level errors and net-level errors separately
 // allow bad SSL certs via option
@@ -22,22 +21,30 @@ type NibMaker = func() Nib
 // track times breakdown - DNS/CONN/SSL/REQ/TTFB/RESP
 // connect timeout, recv timeout, forced overall timeout
 
+func init() {
+	RegisterNib("dummy", NibDriver{
+		Description: "does not do any network, produces random results",
+		Factory: func(conf ProtoConf, options interface{}) (NibMaker, error) {
+			return func() Nib {
+				return &DummyNib{}
+			}, nil
+		},
+	})
+}
+
 type DummyNib struct {
 }
 
 func (d DummyNib) Punch(item *PayloadItem) *OutputItem {
 	now := time.Now()
-	o := &OutputItem{
-		StartTime:      now,
-		Status:         uint16((1 + now.Unix()%5) * 100),
-		Label:          "label#" + strconv.Itoa(int(now.Unix()%3)),
-		ReqBytes:       item.Payload,
-		SentBytesCount: uint64(item.PayloadLen),
-		RespBytes:      item.Payload,
-		RespBytesCount: uint64(item.PayloadLen),
-	}
-
-	o.LabelIdx = 0
+	o := NewOutputItem()
+	o.StartTime = now
+	o.Status = uint16((1 + now.Unix()%5) * 100)
+	o.Label = "label#" + strconv.Itoa(int(now.Unix()%3))
+	o.ReqBytes = item.Payload
+	o.SentBytesCount = uint64(item.PayloadLen)
+	o.RespBytes = item.Payload
+	o.RespBytesCount = uint64(item.PayloadLen)
 
 	duration := time.Duration(now.Unix()%100) * time.Microsecond
 	time.Sleep(duration)
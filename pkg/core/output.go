@@ -2,17 +2,16 @@ package core
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
-	"encoding/json"
-	"errors"
-	"fmt"
 	log "github.com/sirupsen/logrus"
 	"io"
 	"math/rand"
 	"os"
-	"strings"
+	"strconv"
 	"sync"
 	"time"
+	"unicode/utf8"
 )
 
 type OutputConf struct {
@@ -38,6 +37,9 @@ type OutputItem struct { // all fields should have fixed types
 
 	Elapsed       time.Duration
 	ConnectTime   time.Duration
+	DNSTime       time.Duration // DNSTime, DialTime and TLSTime are the parts of ConnectTime for new connection
+	DialTime      time.Duration
+	TLSTime       time.Duration
 	SentTime      time.Duration
 	FirstByteTime time.Duration
 	ReadTime      time.Duration
@@ -48,10 +50,33 @@ type OutputItem struct { // all fields should have fixed types
 
 	SentBytesCount uint64
 	RespBytesCount uint64
-	ReqBytes       []byte `json:"-"`
-	RespBytes      []byte `json:"-"`
+	ReqBytes       []byte        `json:"-"`
+	RespBytes      []byte        `json:"-"`
+	RespBuffer     *bytes.Buffer `json:"-"` // pooled storage of RespBytes, if any
+	MatchBytes     []byte        `json:"-"` // normalized response for extractors and asserts, RespBytes are used if nil
+	MatchBuffer    *bytes.Buffer `json:"-"`
 
 	strIndex *StrIndex
+	payload  *PayloadItem // released together with the item
+}
+
+// SetRespBuffer takes ownership of pooled buffer holding response bytes
+func (i *OutputItem) SetRespBuffer(buf *bytes.Buffer) {
+	i.RespBuffer = buf
+	i.RespBytes = buf.Bytes()
+}
+
+// SetMatchBuffer takes ownership of pooled buffer holding normalized response
+func (i *OutputItem) SetMatchBuffer(buf *bytes.Buffer) {
+	i.MatchBuffer = buf
+	i.MatchBytes = buf.Bytes()
+}
+
+func (i *OutputItem) matchData() []byte {
+	if i.MatchBytes != nil {
+		return i.MatchBytes
+	}
+	return i.RespBytes
 }
 
 func (i *OutputItem) EndWithError(err error) *OutputItem {
@@ -62,22 +87,34 @@ func (i *OutputItem) EndWithError(err error) *OutputItem {
 
 func (i *OutputItem) ExtractValues(extractors map[string]*ExtractRegex, values ValMap) {
 	placeholder := []byte("NOT_FOUND") // TODO: parameterize it
+	var resp *parsedResponse           // parsed only when structured extractors are used
 	for name, outSpec := range extractors {
-		limit := outSpec.MatchNo + 1
-		if outSpec.MatchNo < 0 {
-			limit = outSpec.MatchNo
-		}
+		var val []byte
+		found := false
+		if outSpec.Kind == "" || outSpec.Kind == ExtractRegexKind {
+			limit := outSpec.MatchNo + 1
+			if outSpec.MatchNo < 0 {
+				limit = outSpec.MatchNo
+			}
+
+			all := outSpec.Re.FindAllSubmatch(i.matchData(), limit)
+			if len(all) > 0 && outSpec.MatchNo < len(all) {
+				val, found = pickMatch(all, outSpec.MatchNo)[outSpec.GroupNo], true
+			}
+		} else {
+			if resp == nil {
+				resp = parseResponse(i.matchData())
+			}
 
-		all := outSpec.Re.FindAllSubmatch(i.RespBytes, limit)
+			all := outSpec.candidates(resp, i.Status)
+			if len(all) > 0 && outSpec.MatchNo < len(all) {
+				val, found = pickMatch(all, outSpec.MatchNo), true
+			}
+		}
 
-		var val []byte
-		if len(all) <= 0 {
-			log.Debugf("Nothing has matched the regex '%s': %v", name, outSpec.String())
+		if !found {
+			log.Debugf("Nothing was extracted for '%s': %v", name, outSpec.String())
 			val = placeholder
-		} else if outSpec.MatchNo >= 0 {
-			val = all[outSpec.MatchNo][outSpec.GroupNo]
-		} else {
-			val = all[rand.Intn(len(all))][outSpec.GroupNo]
 		}
 
 		values[name] = make([]byte, len(val))
@@ -85,25 +122,38 @@ func (i *OutputItem) ExtractValues(extractors map[string]*ExtractRegex, values V
 	}
 }
 
-func (i *OutputItem) Assert(asserts []*AssertItem) {
-	problems := ""
-	if i.Error != nil {
-		problems = i.Error.Error()
+// pickMatch takes match by number, -1 means random one
+func pickMatch[T any](all []T, matchNo int) T {
+	if matchNo >= 0 {
+		return all[matchNo]
 	}
+	return all[rand.Intn(len(all))]
+}
 
-	for _, a := range asserts {
-		found := a.Re.Find(i.RespBytes) == nil
-		if found != a.Invert {
-			i := ""
-			if a.Invert {
-				i = "inverted "
-			}
-			problems += fmt.Sprintf("\nAssert failed on %sregexp: %s", i, a.Re)
-		}
+// BinaryMagic starts binary results file, followed by uint16 format version and uint16 record size
+const BinaryMagic = "ENCB"
+
+// BinaryVersion 2 added DNS, dial and TLS times after ConnectTime, version 1 files have no header
+const BinaryVersion uint16 = 2
+
+// BinaryRecordSize is the length of single record written by WriteBinary
+const BinaryRecordSize = 4 + 2 + 2 + 4 + 8*8 + 4 + 2 + 8 + 8
+
+func WriteBinaryHeader(fd io.Writer) {
+	endian := binary.LittleEndian
+	_, err := fd.Write([]byte(BinaryMagic))
+	if err != nil {
+		panic(err)
+	}
+
+	err = binary.Write(fd, endian, BinaryVersion)
+	if err != nil {
+		panic(err)
 	}
 
-	if problems != "" {
-		i.Error = errors.New(strings.TrimSpace(problems))
+	err = binary.Write(fd, endian, uint16(BinaryRecordSize))
+	if err != nil {
+		panic(err)
 	}
 }
 
@@ -139,6 +189,21 @@ func (i *OutputItem) WriteBinary(fd io.Writer) {
 		panic(err)
 	}
 
+	err = binary.Write(fd, endian, i.DNSTime.Seconds())
+	if err != nil {
+		panic(err)
+	}
+
+	err = binary.Write(fd, endian, i.DialTime.Seconds())
+	if err != nil {
+		panic(err)
+	}
+
+	err = binary.Write(fd, endian, i.TLSTime.Seconds())
+	if err != nil {
+		panic(err)
+	}
+
 	err = binary.Write(fd, endian, i.SentTime.Seconds())
 	if err != nil {
 		panic(err)
@@ -185,6 +250,100 @@ func (i *OutputItem) StringFriendly() {
 	}
 }
 
+// AppendJSON encodes item the same way json.Marshal does, without allocations
+func (i *OutputItem) AppendJSON(b []byte) []byte {
+	b = append(b, `{"StartTS":`...)
+	b = strconv.AppendUint(b, uint64(i.StartTS), 10)
+	b = append(b, `,"Status":`...)
+	b = strconv.AppendUint(b, uint64(i.Status), 10)
+	b = append(b, `,"ErrorStr":`...)
+	b = appendJSONString(b, i.ErrorStr)
+	b = append(b, `,"Concurrency":`...)
+	b = strconv.AppendUint(b, uint64(i.Concurrency), 10)
+	b = append(b, `,"Elapsed":`...)
+	b = strconv.AppendInt(b, int64(i.Elapsed), 10)
+	b = append(b, `,"ConnectTime":`...)
+	b = strconv.AppendInt(b, int64(i.ConnectTime), 10)
+	b = append(b, `,"DNSTime":`...)
+	b = strconv.AppendInt(b, int64(i.DNSTime), 10)
+	b = append(b, `,"DialTime":`...)
+	b = strconv.AppendInt(b, int64(i.DialTime), 10)
+	b = append(b, `,"TLSTime":`...)
+	b = strconv.AppendInt(b, int64(i.TLSTime), 10)
+	b = append(b, `,"SentTime":`...)
+	b = strconv.AppendInt(b, int64(i.SentTime), 10)
+	b = append(b, `,"FirstByteTime":`...)
+	b = strconv.AppendInt(b, int64(i.FirstByteTime), 10)
+	b = append(b, `,"ReadTime":`...)
+	b = strconv.AppendInt(b, int64(i.ReadTime), 10)
+	b = append(b, `,"Worker":`...)
+	b = strconv.AppendUint(b, uint64(i.Worker), 10)
+	b = append(b, `,"Label":`...)
+	b = appendJSONString(b, i.Label)
+	b = append(b, `,"SentBytesCount":`...)
+	b = strconv.AppendUint(b, i.SentBytesCount, 10)
+	b = append(b, `,"RespBytesCount":`...)
+	b = strconv.AppendUint(b, i.RespBytesCount, 10)
+	return append(b, '}')
+}
+
+const hexDigits = "0123456789abcdef"
+
+// appendJSONString follows encoding/json escaping, including HTML-safe characters and invalid UTF-8
+func appendJSONString(b []byte, s string) []byte {
+	b = append(b, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if c := s[i]; c < utf8.RuneSelf {
+			if c >= 0x20 && c != '"' && c != '\\' && c != '<' && c != '>' && c != '&' {
+				i++
+				continue
+			}
+
+			b = append(b, s[start:i]...)
+			switch c {
+			case '"', '\\':
+				b = append(b, '\\', c)
+			case '\b':
+				b = append(b, '\\', 'b')
+			case '\f':
+				b = append(b, '\\', 'f')
+			case '\n':
+				b = append(b, '\\', 'n')
+			case '\r':
+				b = append(b, '\\', 'r')
+			case '\t':
+				b = append(b, '\\', 't')
+			default:
+				b = append(b, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			b = append(b, s[start:i]...)
+			b = append(b, "\ufffd"...)
+			i += size
+			start = i
+			continue
+		}
+
+		if r == '\u2028' || r == '\u2029' {
+			b = append(b, s[start:i]...)
+			b = append(b, '\\', 'u', '2', '0', '2', hexDigits[r&0xF])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	b = append(b, s[start:]...)
+	return append(b, '"')
+}
+
 type Output struct {
 	Outs []SingleOut
 
@@ -214,6 +373,7 @@ func (m *Output) background() {
 		for _, out := range m.Outs {
 			out.Push(res)
 		}
+		res.Release()
 	}
 }
 
@@ -248,9 +408,11 @@ func NewOutput(conf OutputConf) *Output {
 			panic(err)
 		}
 
+		writer := bufio.NewWriter(file)
+		WriteBinaryHeader(writer)
 		out.Outs = append(out.Outs, &BinaryOut{
 			fd:     file,
-			writer: bufio.NewWriter(file),
+			writer: writer,
 			mx:     new(sync.Mutex),
 		})
 	}
@@ -282,20 +444,17 @@ type LDJSONOut struct {
 	writer *bufio.Writer
 	fd     *os.File
 	mx     *sync.Mutex
+	buf    []byte
 }
 
 func (L *LDJSONOut) Push(item *OutputItem) {
 	item.StringFriendly()
-	data, err := json.Marshal(item)
-	if err != nil {
-		panic(err)
-	}
-	data = append(data, 13) // \r\n
-	data = append(data, 10) // \n
 
 	L.mx.Lock()
 	defer L.mx.Unlock()
-	_, err = L.writer.Write(data)
+	L.buf = item.AppendJSON(L.buf[:0])
+	L.buf = append(L.buf, 13, 10) // \r\n
+	_, err := L.writer.Write(L.buf)
 	if err != nil {
 		panic(err)
 	}
@@ -311,21 +470,18 @@ func (L *LDJSONOut) Close() {
 type ReqRespOut struct {
 	writer *bufio.Writer
 	fd     *os.File
-	Level  uint16 // 0 would write all, 400 - all above 400, 600 - all non-http
+	Level  uint16 // 0 would write all, 400 - all above 400, 600 - failed gRPC calls and network errors
+	buf    []byte
 }
 
-func (d ReqRespOut) Push(item *OutputItem) {
+func (d *ReqRespOut) Push(item *OutputItem) {
 	if item.Status >= d.Level {
 		item.StringFriendly()
 		// meta
-		data, err := json.Marshal(item)
-		if err != nil {
-			panic(err)
-		}
-		data = append(data, 13) // \r\n
-		data = append(data, 10) // \n
+		d.buf = item.AppendJSON(d.buf[:0])
+		d.buf = append(d.buf, 13, 10) // \r\n
 
-		_, err = d.writer.Write(data)
+		_, err := d.writer.Write(d.buf)
 		if err != nil {
 			panic(err)
 		}
@@ -337,7 +493,7 @@ func (d ReqRespOut) Push(item *OutputItem) {
 	}
 }
 
-func (d ReqRespOut) Close() {
+func (d *ReqRespOut) Close() {
 	_ = d.writer.Flush()
 	_ = d.fd.Close()
 }
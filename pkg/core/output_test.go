@@ -1,10 +1,14 @@
 package core
 
 import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
 	"io"
 	"os"
 	"regexp"
 	"testing"
+	"time"
 )
 
 func tmp() string {
@@ -64,6 +68,46 @@ func TestExtract(t *testing.T) {
 	}
 }
 
+func TestExtractStructured(t *testing.T) {
+	resp := "HTTP/1.1 201 Created\r\nLocation: /items/5\r\nSet-Cookie: other=1\r\nset-cookie: sid=\"s3cr3t\"; Path=/; HttpOnly\r\n\r\n" +
+		`{"item": {"id": 5, "tags": ["a", "b"]}}`
+	item := OutputItem{Status: 201, RespBytes: []byte(resp)}
+
+	extrs := map[string]*ExtractRegex{
+		"id":      {Kind: ExtractJSONPathKind, Expr: "$.item.id"},
+		"tag":     {Kind: ExtractJSONPathKind, Expr: "$.item.tags[*]", MatchNo: 1},
+		"nothing": {Kind: ExtractJSONPathKind, Expr: "$.item.tags[*]", MatchNo: 2},
+		"loc":     {Kind: ExtractHeaderKind, Expr: "location"},
+		"sid":     {Kind: ExtractCookieKind, Expr: "sid"},
+		"code":    {Kind: ExtractStatusKind},
+	}
+	for _, e := range extrs {
+		if err := e.prepare(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	vals := ValMap{}
+	item.ExtractValues(extrs, vals)
+	expected := map[string]string{"id": "5", "tag": "b", "nothing": "NOT_FOUND", "loc": "/items/5", "sid": "s3cr3t", "code": "201"}
+	for name, val := range expected {
+		if string(vals[name]) != val {
+			t.Errorf("Wrong value for %s: %s", name, vals[name])
+		}
+	}
+
+	// non-HTTP response is all body
+	item = OutputItem{RespBytes: []byte(`{"item": {"id": 7}}`)}
+	item.ExtractValues(extrs, vals)
+	if string(vals["id"]) != "7" || string(vals["loc"]) != "NOT_FOUND" {
+		t.Errorf("Wrong values for plain JSON: %s %s", vals["id"], vals["loc"])
+	}
+
+	if (&ExtractRegex{Kind: "xpath"}).prepare() == nil || (&ExtractRegex{Kind: ExtractHeaderKind}).prepare() == nil {
+		t.Errorf("Should fail on unknown kind and missing name")
+	}
+}
+
 func TestAssert(t *testing.T) {
 	item := OutputItem{Label: "newlabel", RespBytes: []byte("test 123")}
 	asserts := []*AssertItem{
@@ -77,3 +121,125 @@ func TestAssert(t *testing.T) {
 		t.Errorf("Should not be errors, got: %s", item.Error)
 	}
 }
+
+func TestAssertKinds(t *testing.T) {
+	resp := "HTTP/1.1 200 OK\r\nContent-Type: application/json\r\n\r\n" + `{"status": "ok", "count": 3}`
+	item := OutputItem{Status: 200, Elapsed: 9 * time.Second, RespBytes: []byte(resp), RespBytesCount: uint64(len(resp))}
+
+	ok, json := "ok", "application/json"
+	passing := []*AssertItem{
+		{Kind: AssertStatusKind, Expr: "200-299,304"},
+		{Kind: AssertStatusKind, Expr: "500-599", Invert: true},
+		{Kind: AssertSizeKind, Expr: "1-"},
+		{Kind: AssertElapsedKind, Expr: "10s"},
+		{Kind: AssertHeaderKind, Expr: "content-type", Value: &json},
+		{Kind: AssertHeaderKind, Expr: "X-Missing", Invert: true},
+		{Kind: AssertJSONPathKind, Expr: "$.status", Value: &ok},
+		{Kind: AssertJSONPathKind, Expr: "$.count"},
+	}
+	for _, a := range passing {
+		if err := a.prepare(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	item.Assert(passing)
+	if item.Error != nil {
+		t.Errorf("Should not fail: %s", item.Error)
+	}
+
+	failing := []*AssertItem{
+		{Kind: AssertStatusKind, Expr: "201,204"},
+		{Kind: AssertSizeKind, Expr: "-10"},
+		{Kind: AssertElapsedKind, Expr: "5s"},
+		{Kind: AssertHeaderKind, Expr: "Content-Type", Value: &ok},
+		{Kind: AssertJSONPathKind, Expr: "$.status", Value: &json},
+		{Kind: AssertJSONPathKind, Expr: "$.missing"},
+		{Kind: AssertJSONPathKind, Expr: "$.count", Invert: true},
+	}
+	for _, a := range failing {
+		if err := a.prepare(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	item.Assert(failing)
+	expected := "Assert failed on status 201,204, got 200\n" +
+		"Assert failed on size -10, got 79 bytes\n" +
+		"Assert failed on elapsed 5s, took 9s\n" +
+		"Assert failed on header Content-Type=ok, got 'application/json'\n" +
+		"Assert failed on jsonpath $.status=application/json, got 'ok'\n" +
+		"Assert failed on jsonpath $.missing, got nothing\n" +
+		"Assert failed on inverted jsonpath $.count, got '3'"
+	if item.Error == nil || item.Error.Error() != expected {
+		t.Errorf("Wrong errors: %v", item.Error)
+	}
+
+	for _, a := range []*AssertItem{{Kind: "xpath"}, {Kind: AssertStatusKind, Expr: "2xx"}, {Kind: AssertStatusKind, Expr: "200-"}, {Kind: AssertElapsedKind, Expr: "5"}} {
+		if a.prepare() == nil {
+			t.Errorf("Should fail to prepare: %v", a)
+		}
+	}
+}
+
+func TestWriteBinary(t *testing.T) {
+	buf := bytes.Buffer{}
+	WriteBinaryHeader(&buf)
+	item := OutputItem{
+		StartTS:     1,
+		Status:      200,
+		Elapsed:     10 * time.Millisecond,
+		ConnectTime: 6 * time.Millisecond,
+		DNSTime:     1 * time.Millisecond,
+		DialTime:    2 * time.Millisecond,
+		TLSTime:     3 * time.Millisecond,
+	}
+	item.WriteBinary(&buf)
+
+	if buf.Len() != 8+BinaryRecordSize {
+		t.Fatalf("Unexpected size: %d", buf.Len())
+	}
+
+	header := struct {
+		Magic   [4]byte
+		Version uint16
+		Size    uint16
+	}{}
+	_ = binary.Read(&buf, binary.LittleEndian, &header)
+	if string(header.Magic[:]) != BinaryMagic || header.Version != BinaryVersion || int(header.Size) != BinaryRecordSize {
+		t.Errorf("Wrong header: %v", header)
+	}
+
+	record := struct {
+		StartTS                     uint32
+		Status, ErrorStrIdx         uint16
+		Concurrency                 uint32
+		Elapsed, Connect, DNS, Dial float64
+		TLS, Sent, FirstByte, Read  float64
+		Worker                      uint32
+		LabelIdx                    uint16
+		SentBytes, RespBytes        uint64
+	}{}
+	_ = binary.Read(&buf, binary.LittleEndian, &record)
+	if record.Status != 200 || record.DNS != 0.001 || record.Dial != 0.002 || record.TLS != 0.003 {
+		t.Errorf("Wrong record: %v", record)
+	}
+}
+
+func TestAppendJSON(t *testing.T) {
+	strs := []string{"", "plain", "quote\" back\\slash", "ctl\n\r\t\b\f\x01\x1f", "<html> & co", "юникод \u2028 \u2029", "bad \xff\xfe utf"}
+	for _, s := range strs {
+		item := OutputItem{
+			StartTS: 1, Status: 200, ErrorStr: s, Concurrency: 3, Elapsed: time.Second, ConnectTime: 5,
+			DNSTime: 1, DialTime: 2, TLSTime: 3, SentTime: 7, FirstByteTime: 8, ReadTime: 9, Worker: 10,
+			Label: s, SentBytesCount: 11, RespBytesCount: 12,
+		}
+
+		expected, err := json.Marshal(&item)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := item.AppendJSON(nil); string(got) != string(expected) {
+			t.Errorf("JSON differs:\n%s\n%s", got, expected)
+		}
+	}
+}
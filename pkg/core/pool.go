@@ -0,0 +1,102 @@
+package core
+
+import (
+	"bytes"
+	"sync"
+)
+
+// Items are reused to spare the garbage collector at high rates. Input takes PayloadItem from pool, Nib takes
+// OutputItem, worker attaches payload to the result, and Output releases both after all SingleOuts consumed it.
+// Nothing may keep references into released items, values extracted from response are copied for that reason.
+
+var payloadPool = sync.Pool{
+	New: func() interface{} {
+		return &PayloadItem{
+			RegexOut: map[string]*ExtractRegex{},
+			Replaces: []string{},
+			Asserts:  []*AssertItem{},
+		}
+	},
+}
+
+var outputPool = sync.Pool{
+	New: func() interface{} {
+		return new(OutputItem)
+	},
+}
+
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+func NewPayloadItem() *PayloadItem {
+	item := payloadPool.Get().(*PayloadItem)
+	item.pooled = true
+	return item
+}
+
+// Release resets the item keeping allocated storage, and puts it back to pool.
+// Items that were not taken from pool, like the ones of predefined input, are left untouched.
+func (i *PayloadItem) Release() {
+	if !i.pooled {
+		return
+	}
+
+	for k := range i.RegexOut {
+		delete(i.RegexOut, k)
+	}
+
+	for n := range i.Asserts { // JSON decoder would fill the stale pointers otherwise
+		i.Asserts[n] = nil
+	}
+
+	payload := i.Payload[:0]
+	if i.borrowed {
+		payload = nil
+	}
+
+	*i = PayloadItem{
+		Payload:     payload,
+		ReplacesIdx: i.ReplacesIdx[:0],
+		Replaces:    i.Replaces[:0],
+		RegexOutIdx: i.RegexOutIdx[:0],
+		RegexOut:    i.RegexOut,
+		AssertsIdx:  i.AssertsIdx[:0],
+		Asserts:     i.Asserts[:0],
+	}
+	payloadPool.Put(i)
+}
+
+func NewOutputItem() *OutputItem {
+	return outputPool.Get().(*OutputItem)
+}
+
+// Release gives back the item with its payload and response buffer
+func (i *OutputItem) Release() {
+	if i.payload != nil {
+		i.payload.Release()
+	}
+
+	if i.RespBuffer != nil {
+		PutBuffer(i.RespBuffer)
+	}
+
+	if i.MatchBuffer != nil {
+		PutBuffer(i.MatchBuffer)
+	}
+
+	*i = OutputItem{}
+	outputPool.Put(i)
+}
+
+// GetBuffer returns empty buffer for recording response data
+func GetBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+func PutBuffer(buf *bytes.Buffer) {
+	buf.Reset()
+	bufferPool.Put(buf)
+}
@@ -0,0 +1,124 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPayloadItemRelease(t *testing.T) {
+	item := NewPayloadItem()
+	meta := `{"plen": 4, "label": "lbl", "replaces": ["a"], "extracts": {"v": {"re": "x"}}, "asserts": [{"re": "y"}], "r": [1]}`
+	if err := json.Unmarshal([]byte(meta), item); err != nil {
+		t.Fatal(err)
+	}
+	item.Payload = append(item.Payload, "test"...)
+
+	item.Release()
+	if item.Label != "" || item.PayloadLen != 0 || len(item.Payload) != 0 || len(item.Replaces) != 0 ||
+		len(item.RegexOut) != 0 || len(item.Asserts) != 0 || len(item.ReplacesIdx) != 0 {
+		t.Errorf("Item should be clean after release: %+v", item)
+	}
+
+	if cap(item.Payload) < 4 || cap(item.Asserts) < 1 || item.Asserts[:1][0] != nil {
+		t.Errorf("Item should keep storage without stale pointers")
+	}
+}
+
+func TestOutputItemRelease(t *testing.T) {
+	buf := GetBuffer()
+	buf.WriteString("response")
+
+	item := NewOutputItem()
+	item.Label = "lbl"
+	item.SetRespBuffer(buf)
+	item.payload = NewPayloadItem()
+	if string(item.RespBytes) != "response" {
+		t.Errorf("Wrong response bytes: %s", item.RespBytes)
+	}
+
+	item.Release()
+	if item.Label != "" || item.RespBytes != nil || item.RespBuffer != nil || item.payload != nil || buf.Len() != 0 {
+		t.Errorf("Item should be clean after release: %+v", item)
+	}
+}
+
+func TestPredefinedItemRelease(t *testing.T) {
+	item := &PayloadItem{Label: "lbl", Payload: []byte("test")}
+	res := NewOutputItem()
+	res.payload = item
+	res.Release()
+
+	if item.Label != "lbl" || string(item.Payload) != "test" {
+		t.Errorf("Item not taken from pool should be left intact: %+v", item)
+	}
+}
+
+const benchRecord = "{\"plen\": 36, \"address\": \"localhost:8070\", \"label\": \"/\"}\nGET / HTTP/1.1\r\nHost: localhost\r\n\r\n\n"
+
+type benchNib struct{}
+
+func (benchNib) Punch(item *PayloadItem) *OutputItem {
+	res := NewOutputItem()
+	res.StartTime = time.Now()
+	res.Status = 200
+	res.SentBytesCount = uint64(len(item.Payload))
+	res.RespBytes = item.Payload
+	res.RespBytesCount = uint64(len(item.Payload))
+	res.Elapsed = time.Now().Sub(res.StartTime)
+	return res
+}
+
+func BenchmarkReadPayloadRecord(b *testing.B) {
+	file := bytes.NewReader([]byte(benchRecord))
+	reader := bufio.NewReaderSize(file, inputBufferSize)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = file.Seek(0, io.SeekStart)
+		reader.Reset(file)
+		item, err := readPayloadRecord(reader, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		item.Release()
+	}
+}
+
+func BenchmarkLDJSONOut(b *testing.B) {
+	out := NewOutput(OutputConf{LDJSONFile: os.DevNull})
+	defer out.Close()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		item := NewOutputItem()
+		item.Label = "label"
+		item.Status = 200
+		item.Elapsed = time.Millisecond
+		out.Push(item)
+	}
+}
+
+func BenchmarkIteration(b *testing.B) {
+	out := NewOutput(OutputConf{LDJSONFile: os.DevNull})
+	defer out.Close()
+	w := &Worker{Nib: benchNib{}, Output: out, Status: NewStatus(), Values: ValMap{}}
+
+	file := bytes.NewReader([]byte(benchRecord))
+	reader := bufio.NewReaderSize(file, inputBufferSize)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = file.Seek(0, io.SeekStart)
+		reader.Reset(file)
+		item, err := readPayloadRecord(reader, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		item.ReplaceValues(w.Values)
+		item.ResolveStrings()
+		w.DoBusy(item)
+	}
+}
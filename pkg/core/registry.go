@@ -0,0 +1,134 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// NibFactory creates maker for configured protocol, options is the value returned by NibDriver.Options after decoding
+type NibFactory = func(conf ProtoConf, options interface{}) (NibMaker, error)
+
+type NibDriver struct {
+	Description string
+	Options     func() interface{} // returns pointer to driver's options with default values, may be nil
+	Factory     NibFactory
+}
+
+// OptionsValidator may be implemented by driver options to check them at config load time
+type OptionsValidator interface {
+	Validate() error
+}
+
+var nibDrivers = map[string]NibDriver{}
+var mxDrivers = new(sync.Mutex)
+
+// RegisterNib makes protocol driver available by name, it is meant to be called from package init()
+func RegisterNib(name string, driver NibDriver) {
+	mxDrivers.Lock()
+	defer mxDrivers.Unlock()
+	if _, ok := nibDrivers[name]; ok {
+		panic(fmt.Sprintf("Protocol driver is already registered: %s", name))
+	}
+
+	if driver.Factory == nil {
+		panic(fmt.Sprintf("Protocol driver has no factory: %s", name))
+	}
+
+	nibDrivers[name] = driver
+}
+
+// RegisteredNibs returns sorted names of registered drivers
+func RegisteredNibs() []string {
+	mxDrivers.Lock()
+	defer mxDrivers.Unlock()
+	return registeredNames()
+}
+
+// NibsUsage describes registered drivers for command-line help
+func NibsUsage() string {
+	mxDrivers.Lock()
+	defer mxDrivers.Unlock()
+	lines := make([]string, 0, len(nibDrivers))
+	for name, driver := range nibDrivers {
+		lines = append(lines, fmt.Sprintf("  %-12s %s", name, driver.Description))
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+func getNibDriver(name string) (NibDriver, error) {
+	mxDrivers.Lock()
+	defer mxDrivers.Unlock()
+	driver, ok := nibDrivers[name]
+	if !ok {
+		return driver, errors.New(fmt.Sprintf("Unsupported protocol driver: '%s', available are: %s", name, strings.Join(registeredNames(), ", ")))
+	}
+	return driver, nil
+}
+
+func registeredNames() []string {
+	names := make([]string, 0, len(nibDrivers))
+	for name := range nibDrivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DecodeProtoOptions decodes and validates driver-specific options section of protocol config
+func DecodeProtoOptions(conf ProtoConf) (interface{}, error) {
+	driver, err := getNibDriver(conf.Driver)
+	if err != nil {
+		return nil, err
+	}
+
+	if driver.Options == nil {
+		if !conf.Options.IsZero() {
+			return nil, errors.New(fmt.Sprintf("Protocol driver '%s' does not support options", conf.Driver))
+		}
+		return nil, nil
+	}
+
+	options := driver.Options()
+	if !conf.Options.IsZero() {
+		// yaml.Node.Decode cannot complain about unknown fields, so we do the round-trip
+		data, err := yaml.Marshal(&conf.Options)
+		if err != nil {
+			return nil, err
+		}
+
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(options); err != nil {
+			return nil, errors.New(fmt.Sprintf("Invalid options for protocol driver '%s': %s", conf.Driver, err))
+		}
+	}
+
+	if validator, ok := options.(OptionsValidator); ok {
+		if err := validator.Validate(); err != nil {
+			return nil, errors.New(fmt.Sprintf("Invalid options for protocol driver '%s': %s", conf.Driver, err))
+		}
+	}
+	return options, nil
+}
+
+// NewNibMaker looks up registered driver and creates the maker for it
+func NewNibMaker(conf ProtoConf) (NibMaker, error) {
+	log.Infof("Client protocol is: %s", conf.Driver)
+	options, err := DecodeProtoOptions(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	driver, err := getNibDriver(conf.Driver)
+	if err != nil {
+		return nil, err
+	}
+	return driver.Factory(conf, options)
+}
@@ -0,0 +1,98 @@
+package core
+
+import (
+	"errors"
+	"gopkg.in/yaml.v3"
+	"strings"
+	"testing"
+)
+
+type testOptions struct {
+	Level int
+}
+
+func (o *testOptions) Validate() error {
+	if o.Level > 10 {
+		return errors.New("level is too high")
+	}
+	return nil
+}
+
+func init() {
+	RegisterNib("test", NibDriver{
+		Description: "test driver",
+		Options: func() interface{} {
+			return &testOptions{Level: 1}
+		},
+		Factory: func(conf ProtoConf, options interface{}) (NibMaker, error) {
+			if options.(*testOptions).Level == 0 {
+				return nil, errors.New("zero level")
+			}
+			return func() Nib {
+				return &DummyNib{}
+			}, nil
+		},
+	})
+}
+
+func protoConf(t *testing.T, txt string) ProtoConf {
+	conf := ProtoConf{}
+	if err := yaml.Unmarshal([]byte(txt), &conf); err != nil {
+		t.Fatal(err)
+	}
+	return conf
+}
+
+func TestNewNibMaker(t *testing.T) {
+	maker, err := NewNibMaker(protoConf(t, "driver: test\noptions:\n  level: 5"))
+	if err != nil || maker() == nil {
+		t.Errorf("Should create maker: %v", err)
+	}
+
+	opts, err := DecodeProtoOptions(protoConf(t, "driver: test"))
+	if err != nil || opts.(*testOptions).Level != 1 {
+		t.Errorf("Should keep defaults: %v %v", opts, err)
+	}
+
+	_, err = NewNibMaker(protoConf(t, "driver: test\noptions:\n  level: 0"))
+	if err == nil || err.Error() != "zero level" {
+		t.Errorf("Factory error should pass through: %v", err)
+	}
+
+	_, err = NewNibMaker(protoConf(t, "driver: test\noptions:\n  level: 11"))
+	if err == nil || !strings.Contains(err.Error(), "level is too high") {
+		t.Errorf("Should fail validation: %v", err)
+	}
+
+	_, err = NewNibMaker(protoConf(t, "driver: test\noptions:\n  unknown: 1"))
+	if err == nil || !strings.Contains(err.Error(), "field unknown not found") {
+		t.Errorf("Should fail on unknown option: %v", err)
+	}
+
+	_, err = NewNibMaker(protoConf(t, "driver: dummy\noptions:\n  level: 1"))
+	if err == nil {
+		t.Errorf("Should fail for driver without options")
+	}
+
+	_, err = NewNibMaker(protoConf(t, "driver: nonexistent"))
+	if err == nil || !strings.Contains(err.Error(), "dummy, test") {
+		t.Errorf("Should list available drivers: %v", err)
+	}
+}
+
+func TestRegisterNibTwice(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Should panic")
+		}
+	}()
+	RegisterNib("test", NibDriver{Factory: func(conf ProtoConf, options interface{}) (NibMaker, error) {
+		return nil, nil
+	}})
+}
+
+func TestNibsUsage(t *testing.T) {
+	if !strings.Contains(NibsUsage(), "test driver") {
+		t.Errorf("No description in usage: %s", NibsUsage())
+	}
+}
@@ -0,0 +1,83 @@
+package core
+
+import (
+	"compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"os"
+	"strings"
+)
+
+// StdinInput is the payload file name to read from standard input
+const StdinInput = "-"
+
+// inputRewindable tells if the input can be opened again to loop over it, streams can only be read once
+func inputRewindable(fname string) bool {
+	if fname == StdinInput {
+		return false
+	}
+
+	info, err := os.Stat(fname)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// readOnce tells if payload records can only be read once, as they are not cached from stream
+func readOnce(config InputConf) bool {
+	streamed := config.Format == "" || config.Format == InputFormatNative || config.Format == InputFormatAccessLog
+	return config.Predefined == nil && streamed && !inputCached(config) && !inputRewindable(config.PayloadFile)
+}
+
+// inputPlain tells if the input is regular uncompressed file, that can be mapped into memory and sent with sendfile
+func inputPlain(fname string) bool {
+	return inputRewindable(fname) && !strings.HasSuffix(fname, ".gz") && !strings.HasSuffix(fname, ".zst")
+}
+
+type decompressedInput struct {
+	io.Reader
+	closers []func() error
+}
+
+func (d *decompressedInput) Close() error {
+	var err error
+	for _, closer := range d.closers {
+		if e := closer(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// openInput opens payload source, decompressing .gz and .zst files on the fly
+func openInput(fname string) (io.ReadCloser, error) {
+	if fname == StdinInput {
+		return io.NopCloser(os.Stdin), nil
+	}
+
+	file, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case strings.HasSuffix(fname, ".gz"):
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+		return &decompressedInput{Reader: gz, closers: []func() error{gz.Close, file.Close}}, nil
+	case strings.HasSuffix(fname, ".zst"):
+		zst, err := zstd.NewReader(file)
+		if err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+		closeZst := func() error {
+			zst.Close()
+			return nil
+		}
+		return &decompressedInput{Reader: zst, closers: []func() error{closeZst, file.Close}}, nil
+	default:
+		return file, nil
+	}
+}
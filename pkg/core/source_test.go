@@ -0,0 +1,117 @@
+package core
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const sourceRecords = "{\"plen\": 5, \"label\": \"first\"}\nfirst\r\n\r\n{\"plen\": 6, \"label\": \"second\"}\nsecond\n"
+
+func writeCompressed(t *testing.T, fname string) string {
+	buf := bytes.Buffer{}
+	switch filepath.Ext(fname) {
+	case ".gz":
+		w := gzip.NewWriter(&buf)
+		_, _ = w.Write([]byte(sourceRecords))
+		_ = w.Close()
+	case ".zst":
+		w, _ := zstd.NewWriter(&buf)
+		_, _ = w.Write([]byte(sourceRecords))
+		_ = w.Close()
+	default:
+		buf.WriteString(sourceRecords)
+	}
+
+	fname = filepath.Join(t.TempDir(), fname)
+	if err := os.WriteFile(fname, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fname
+}
+
+func readLabels(ch InputChannel) string {
+	labels := make([]string, 0)
+	for item := range ch {
+		labels = append(labels, item.Label+"="+string(item.Payload))
+		item.Release()
+	}
+	return strings.Join(labels, ",")
+}
+
+func TestCompressedInput(t *testing.T) {
+	for _, name := range []string{"payload.txt", "payload.txt.gz", "payload.txt.zst"} {
+		fname := writeCompressed(t, name)
+		for _, cached := range []bool{false, true} {
+			labels := readLabels(NewInput(InputConf{PayloadFile: fname, IterationLimit: 2, CacheInMemory: cached}))
+			if labels != "first=first,second=second,first=first,second=second" {
+				t.Errorf("Wrong records from %s, cached %v: %s", name, cached, labels)
+			}
+		}
+	}
+}
+
+func TestStdinInput(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdin := os.Stdin
+	os.Stdin = r
+	defer func() {
+		os.Stdin = stdin
+		_ = r.Close()
+	}()
+
+	go func() {
+		_, _ = w.Write([]byte(sourceRecords))
+		_ = w.Close()
+	}()
+
+	conf := InputConf{PayloadFile: StdinInput}
+	if !readOnce(conf) || readOnce(InputConf{PayloadFile: StdinInput, CacheInMemory: true}) {
+		t.Errorf("Only uncached stream is read once")
+	}
+
+	labels := readLabels(NewInput(conf))
+	if labels != "first=first,second=second" {
+		t.Errorf("Stream should be read once: %s", labels)
+	}
+}
+
+func TestStdinSchedule(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdin := os.Stdin
+	os.Stdin = r
+	defer func() {
+		os.Stdin = stdin
+		_ = r.Close()
+	}()
+
+	go func() {
+		_, _ = w.Write([]byte("{\"plen\": 5, \"offset\": 10}\nfirst\n{\"plen\": 6, \"offset\": 20}\nsecond\n"))
+		_ = w.Close()
+	}()
+
+	items, schedule := NewInputSchedule(InputConf{PayloadFile: StdinInput, IterationLimit: 3})
+	offsets := make([]time.Duration, 0)
+	for offset := range schedule {
+		offsets = append(offsets, offset)
+		(<-items).Release()
+	}
+
+	if !reflect.DeepEqual(offsets, []time.Duration{10 * time.Millisecond, 20 * time.Millisecond}) {
+		t.Errorf("Stream should be scheduled once: %v", offsets)
+	}
+}
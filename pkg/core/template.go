@@ -0,0 +1,150 @@
+package core
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	log "github.com/sirupsen/logrus"
+	mrand "math/rand"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// FuncContext is the worker state available to template functions
+type FuncContext struct {
+	WorkerIndex int
+	Iteration   int
+}
+
+var counters = map[string]*int64{}
+var countersMx sync.Mutex
+
+func nextCounter(name string) int64 {
+	countersMx.Lock()
+	cnt, ok := counters[name]
+	if !ok {
+		cnt = new(int64)
+		counters[name] = cnt
+	}
+	countersMx.Unlock()
+	return atomic.AddInt64(cnt, 1)
+}
+
+const randomChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// evalTemplate evaluates "name" or ":function:args:" followed by optional "|filter" chain,
+// false is returned for expressions evaluated later by protocol driver
+func evalTemplate(expr string, values ValMap, ctx *FuncContext) ([]byte, bool) {
+	parts := strings.Split(expr, "|")
+	base := parts[0]
+
+	var val []byte
+	if len(base) > 1 && base[0] == ':' && base[len(base)-1] == ':' {
+		args := strings.Split(base[1:len(base)-1], ":")
+		if args[0] == "content-length" { // handled by http driver after all replaces
+			return nil, false
+		}
+		val = evalFunction(args, ctx)
+	} else {
+		v, ok := values[base]
+		if !ok {
+			v = []byte("NO_VALUE") // TODO: document that it works like that
+		}
+		val = v
+	}
+
+	for _, filter := range parts[1:] {
+		switch filter {
+		case "base64":
+			val = []byte(base64.StdEncoding.EncodeToString(val))
+		case "urlencode":
+			val = []byte(url.QueryEscape(string(val)))
+		default:
+			log.Warningf("Unknown template filter '%s' in: %s", filter, expr)
+		}
+	}
+	return val, true
+}
+
+func evalFunction(args []string, ctx *FuncContext) []byte {
+	intArg := func(n int, def int) int {
+		if len(args) <= n {
+			return def
+		}
+		v, err := strconv.Atoi(args[n])
+		if err != nil {
+			log.Warningf("Template function '%s' argument is not integer: %s", args[0], args[n])
+			return def
+		}
+		return v
+	}
+
+	switch args[0] {
+	case "uuid":
+		return newUUID()
+	case "random-int":
+		min, max := intArg(1, 0), intArg(2, 100)
+		span := max - min + 1
+		if max < min || span <= 0 { // reversed or overflowing range
+			log.Warningf("Template function '%s' has invalid range: %d..%d", args[0], min, max)
+			break
+		}
+		return []byte(strconv.Itoa(min + mrand.Intn(span)))
+	case "random-string":
+		length := intArg(1, 16)
+		if length <= 0 {
+			log.Warningf("Template function '%s' needs positive length, got: %d", args[0], length)
+			break
+		}
+		res := make([]byte, length)
+		for n := range res {
+			res[n] = randomChars[mrand.Intn(len(randomChars))]
+		}
+		return res
+	case "timestamp-ms":
+		return []byte(strconv.FormatInt(time.Now().UnixMilli(), 10))
+	case "worker-index":
+		if ctx != nil {
+			return []byte(strconv.Itoa(ctx.WorkerIndex))
+		}
+	case "iteration":
+		if ctx != nil {
+			return []byte(strconv.Itoa(ctx.Iteration))
+		}
+	case "counter":
+		name := ""
+		if len(args) > 1 {
+			name = args[1]
+		}
+		return []byte(strconv.FormatInt(nextCounter(name), 10))
+	default:
+		log.Warningf("Unknown template function: %s", args[0])
+	}
+	return []byte("NO_VALUE")
+}
+
+// newUUID makes random version 4 UUID
+func newUUID() []byte {
+	var u [16]byte
+	if _, err := rand.Read(u[:]); err != nil {
+		panic(err)
+	}
+	u[6] = (u[6] & 0x0f) | 0x40
+	u[8] = (u[8] & 0x3f) | 0x80
+
+	res := make([]byte, 36)
+	hex.Encode(res[0:8], u[0:4])
+	res[8] = '-'
+	hex.Encode(res[9:13], u[4:6])
+	res[13] = '-'
+	hex.Encode(res[14:18], u[6:8])
+	res[18] = '-'
+	hex.Encode(res[19:23], u[8:10])
+	res[23] = '-'
+	hex.Encode(res[24:], u[10:])
+	return res
+}
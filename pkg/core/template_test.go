@@ -0,0 +1,54 @@
+package core
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestTemplateFunctions(t *testing.T) {
+	item := PayloadItem{
+		Label:   "${:worker-index:}",
+		Address: "http://host/${:iteration:}",
+		Payload: []byte("${:uuid:} ${:uuid:} ${:random-int:5:7:} ${:random-string:8:} ${:timestamp-ms:} " +
+			"${:counter:tpl:} ${var|base64} ${var|urlencode} ${var|base64|urlencode} ${:content-length:} ${:nope:}"),
+		Replaces: []string{":uuid:", ":random-int:5:7:", ":random-string:8:", ":timestamp-ms:", ":worker-index:",
+			":iteration:", ":counter:tpl:", "var|base64", "var|urlencode", "var|base64|urlencode", ":content-length:", ":nope:"},
+	}
+	item.ReplaceValuesFor(ValMap{"var": []byte("a b/c?")}, &FuncContext{WorkerIndex: 3, Iteration: 42})
+
+	re := regexp.MustCompile(`^([0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}) ([0-9a-f-]{36}) ([5-7]) ([a-zA-Z0-9]{8}) (\d{13}) (\d+) ` +
+		`YSBiL2M/ a\+b%2Fc%3F YSBiL2M%2F \$\{:content-length:} NO_VALUE$`)
+	match := re.FindSubmatch(item.Payload)
+	if match == nil {
+		t.Fatalf("Wrong payload: %s", item.Payload)
+	}
+
+	if string(match[1]) != string(match[2]) {
+		t.Errorf("Function should be evaluated once per item")
+	}
+
+	if item.Label != "3" || item.Address != "http://host/42" {
+		t.Errorf("Wrong label or address: %s %s", item.Label, item.Address)
+	}
+
+	cnt, _ := strconv.Atoi(string(match[6]))
+	if next := evalFunction([]string{"counter", "tpl"}, nil); string(next) != strconv.Itoa(cnt+1) {
+		t.Errorf("Counter should increment: %d then %s", cnt, next)
+	}
+}
+
+func TestTemplateFunctionBadArgs(t *testing.T) {
+	bad := [][]string{
+		{"random-string", "-1"},
+		{"random-string", "0"},
+		{"random-int", "7", "5"},
+		{"random-int", strconv.Itoa(math.MinInt), strconv.Itoa(math.MaxInt)},
+	}
+	for _, args := range bad {
+		if res := evalFunction(args, nil); string(res) != "NO_VALUE" {
+			t.Errorf("Arguments %v should be rejected, got: %s", args, res)
+		}
+	}
+}
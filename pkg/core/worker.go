@@ -15,13 +15,16 @@ type Worker struct {
 	Abort          <-chan struct{}
 	InputPayload   InputChannel
 	InputSchedule  ScheduleChannel
+	InputOffsets   bool // schedule is taken from input, so item offset is its exact start time
 	Output         *Output
 	Values         map[string][]byte
 	Finished       bool
 	IterationCount int
 	Status         *Status
+	Feeds          []*CSVFeed
 
 	stopped bool
+	funcCtx FuncContext
 }
 
 func (w *Worker) Run() {
@@ -51,13 +54,32 @@ func (w *Worker) Iteration() bool {
 	if item == nil {
 		return true
 	}
-	item.ReplaceValues(w.Values)
+	if w.InputOffsets { // workers may take items in other order than the schedule
+		offset = time.Duration(item.Offset * float64(time.Millisecond))
+	}
+	w.IterationCount += 1
+	w.funcCtx.WorkerIndex = w.Index
+	w.funcCtx.Iteration = w.IterationCount
+	for _, feed := range w.Feeds {
+		if !feed.Load(w.Values, w.Index, w.IterationCount) {
+			log.Infof("[%d] CSV feed rows are exhausted", w.Index)
+			item.Release()
+			w.Status.DecWaiting()
+			return true
+		}
+	}
+	item.ReplaceValuesFor(w.Values, &w.funcCtx)
 	w.Status.DecWaiting()
 
 	w.Status.IncWorking()
-	w.IterationCount += 1
 
 	expectedStart := w.StartTime.Add(offset)
+	if item.ThinkTime > 0 { // recorded pause can't start before the scheduled time
+		if now := time.Now(); now.After(expectedStart) {
+			expectedStart = now
+		}
+		expectedStart = expectedStart.Add(item.ThinkTime)
+	}
 	delay := expectedStart.Sub(time.Now())
 	if delay > 0 {
 		log.Debugf("[%d] Sleeping: %dns", w.Index, delay)
@@ -69,19 +91,23 @@ func (w *Worker) Iteration() bool {
 
 	if !w.stopped {
 		item.ResolveStrings()
-		res := w.DoBusy(item)
-		w.Status.StartMissed(res.StartTime.Sub(expectedStart))
+		start := w.DoBusy(item)
+		w.Status.StartMissed(start.Sub(expectedStart))
+	} else {
+		item.Release()
 	}
 	w.Status.DecWorking()
 	return false
 }
 
-func (w *Worker) DoBusy(item *PayloadItem) *OutputItem {
+// DoBusy hands both item and its result to output, only the start time is returned as result is released after output
+func (w *Worker) DoBusy(item *PayloadItem) time.Time {
 	w.Status.IncBusy()
 	res := w.Nib.Punch(item)
 	res.StartTS = uint32(res.StartTime.Unix()) // TODO: use nanoseconds
 	res.Worker = uint32(w.Index)
 	res.ReqBytes = item.Payload
+	res.payload = item
 
 	if res.Label == "" { // allow Nib to generate own label
 		res.Label = item.Label
@@ -95,8 +121,9 @@ func (w *Worker) DoBusy(item *PayloadItem) *OutputItem {
 	w.Status.DecBusy()
 	res.ExtractValues(item.RegexOut, w.Values)
 	res.Assert(item.Asserts)
+	start := res.StartTime
 	w.Output.Push(res)
-	return res
+	return start
 }
 
 func (w *Worker) Stop() {
@@ -117,10 +144,12 @@ func NewBasicWorker(index int, abort chan struct{}, wl *BaseWorkload, scheduleCh
 		Abort:         abort,
 		InputPayload:  wl.InputPayload(),
 		InputSchedule: scheduleChan,
+		InputOffsets:  wl.InputSchedule != nil,
 		Output:        wl.Output,
 		StartTime:     wl.StartTime,
 		Status:        wl.Status,
 		Values:        valuesCopy,
+		Feeds:         wl.Feeds,
 	}
 	return b
 }
@@ -2,6 +2,7 @@ package core
 
 import (
 	log "github.com/sirupsen/logrus"
+	"math"
 	"time"
 )
 
@@ -17,6 +18,7 @@ type WorkloadMode = string
 const (
 	WorkloadOpen   WorkloadMode = "open"
 	WorkloadClosed WorkloadMode = "closed"
+	WorkloadFile   WorkloadMode = "file" // open workload with schedule from input record offsets
 )
 
 type WorkerConf struct {
@@ -28,15 +30,17 @@ type WorkerConf struct {
 }
 
 type BaseWorkload struct {
-	Workers      []*Worker
-	NibMaker     NibMaker
-	StartTime    time.Time
-	Output       *Output
-	InputPayload func() InputChannel
-	Scenario     []WorkloadLevel
-	cnt          int
-	Status       *Status
-	Values       ValMap
+	Workers       []*Worker
+	NibMaker      NibMaker
+	StartTime     time.Time
+	Output        *Output
+	InputPayload  func() InputChannel
+	InputSchedule func() ScheduleChannel // recorded schedule from input, if it has one
+	Scenario      []WorkloadLevel
+	cnt           int
+	Status        *Status
+	Values        ValMap
+	Feeds         []*CSVFeed
 }
 
 func (s *BaseWorkload) SpawnWorker(scheduleChan ScheduleChannel) {
@@ -57,7 +61,26 @@ func (s *BaseWorkload) Stop() {
 
 func NewBaseWorkload(maker NibMaker, output *Output, inputConfig InputConf, wconf WorkerConf, status *Status) *BaseWorkload {
 	var payloadGetter func() InputChannel
-	if inputConfig.EnableRegexes {
+	var scheduleGetter func() ScheduleChannel
+	ownInputs := inputConfig.EnableRegexes
+	if ownInputs && readOnce(inputConfig) {
+		log.Warningf("Workers will share payload input stream, so request sequence is not kept for each of them")
+		ownInputs = false
+	}
+
+	if wconf.Mode == WorkloadFile || inputConfig.Format == InputFormatAccessLog && wconf.Mode == WorkloadOpen {
+		if ownInputs {
+			log.Warningf("Workers will share payload input to follow schedule taken from it, so request sequence is not kept for each of them")
+		}
+
+		inputChannel, scheduleChannel := NewInputSchedule(inputConfig)
+		payloadGetter = func() InputChannel {
+			return inputChannel
+		}
+		scheduleGetter = func() ScheduleChannel {
+			return scheduleChannel
+		}
+	} else if ownInputs {
 		payloadGetter = func() InputChannel {
 			inputChannel := NewInput(inputConfig)
 			return inputChannel
@@ -74,14 +97,38 @@ func NewBaseWorkload(maker NibMaker, output *Output, inputConfig InputConf, wcon
 		values[k] = []byte(v)
 	}
 
+	feeds := make([]*CSVFeed, 0)
+	for _, fconf := range inputConfig.CSVFeeds {
+		feed, err := NewCSVFeed(fconf, maxWorkers(wconf))
+		if err != nil {
+			panic(err)
+		}
+		feeds = append(feeds, feed)
+	}
+
 	return &BaseWorkload{
-		Workers:      make([]*Worker, 0),
-		NibMaker:     maker,
-		StartTime:    time.Now(),
-		Output:       output,
-		InputPayload: payloadGetter,
-		Scenario:     wconf.WorkloadSchedule,
-		Status:       status,
-		Values:       values,
+		Workers:       make([]*Worker, 0),
+		NibMaker:      maker,
+		StartTime:     time.Now(),
+		Output:        output,
+		InputPayload:  payloadGetter,
+		InputSchedule: scheduleGetter,
+		Scenario:      wconf.WorkloadSchedule,
+		Status:        status,
+		Values:        values,
+		Feeds:         feeds,
+	}
+}
+
+// maxWorkers gives the limit of workers to be spawned, zero if unknown
+func maxWorkers(wconf WorkerConf) int {
+	if wconf.Mode != WorkloadClosed {
+		return wconf.MaxWorkers
+	}
+
+	res := 0.0
+	for _, level := range wconf.WorkloadSchedule {
+		res = math.Max(res, math.Max(level.LevelStart, level.LevelEnd))
 	}
+	return int(res)
 }
@@ -0,0 +1,223 @@
+package grpc
+
+import (
+	"encarno/pkg/core"
+	"encarno/pkg/http"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/http2/hpack"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StatusBase is added to failed grpc-status codes, so they don't collide with HTTP codes and 999 for network errors
+const StatusBase = 600
+
+// StatusOK is reported for grpc-status 0, below StatusBase so successful calls stay out of error trace levels
+const StatusOK = 200
+
+const prefixLen = 5
+
+var codeNames = []string{
+	"OK", "CANCELLED", "UNKNOWN", "INVALID_ARGUMENT", "DEADLINE_EXCEEDED", "NOT_FOUND", "ALREADY_EXISTS",
+	"PERMISSION_DENIED", "RESOURCE_EXHAUSTED", "FAILED_PRECONDITION", "ABORTED", "OUT_OF_RANGE", "UNIMPLEMENTED",
+	"INTERNAL", "UNAVAILABLE", "DATA_LOSS", "UNAUTHENTICATED",
+}
+
+// Options is the driver-specific section of protocol config
+type Options struct {
+	FramedPayload bool // payload records already contain length-prefixed messages
+}
+
+func init() {
+	core.RegisterNib("grpc", core.NibDriver{
+		Description: "gRPC calls with pre-serialized protobuf payloads",
+		Options: func() interface{} {
+			return &Options{}
+		},
+		Factory: func(conf core.ProtoConf, options interface{}) (core.NibMaker, error) {
+			opts := options.(*Options)
+			pool := http.NewH2Pool(http.NewPoolFromConf(conf))
+
+			return func() core.Nib {
+				return &Nib{
+					Pool:   pool,
+					Framed: opts.FramedPayload,
+				}
+			}, nil
+		},
+	})
+}
+
+// Nib sends pre-serialized protobuf messages as gRPC calls over HTTP/2
+type Nib struct {
+	Pool   *http.H2Pool
+	Framed bool // payload already contains length-prefixed messages, needed for client streaming
+}
+
+func (n *Nib) Punch(item *core.PayloadItem) *core.OutputItem {
+	outItem := core.NewOutputItem()
+	outItem.StartTime = time.Now()
+
+	target, method, err := splitAddress(item.Address, item.Label)
+	if err != nil {
+		outItem.EndWithError(err)
+		return outItem
+	}
+
+	scheme, _ := http.UnixScheme(target.Scheme)
+	authority := target.Host
+	if authority == "" {
+		authority = "localhost"
+	}
+
+	req := &http.H2Request{
+		Headers: []hpack.HeaderField{
+			{Name: ":method", Value: "POST"},
+			{Name: ":scheme", Value: scheme},
+			{Name: ":authority", Value: authority},
+			{Name: ":path", Value: method},
+			{Name: "content-type", Value: "application/grpc"},
+			{Name: "te", Value: "trailers"},
+			{Name: "grpc-timeout", Value: strconv.FormatInt(n.Pool.ConnPool.Timeout.Milliseconds(), 10) + "m"},
+		},
+		Body: n.frame(item.Payload),
+	}
+
+	if len(item.RegexOut) > 0 || len(item.Asserts) > 0 {
+		req.RecordLimit = 0
+	} else {
+		req.RecordLimit = 1024 * 1024
+	}
+
+	before := time.Now()
+	conn, times, err := n.Pool.Get(target.String(), "")
+	connected := time.Now()
+	outItem.ConnectTime = connected.Sub(before)
+	times.Fill(outItem)
+	if err != nil {
+		outItem.EndWithError(err)
+		return outItem
+	}
+	defer n.Pool.Release(conn)
+
+	log.Debugf("Calling gRPC method %s with %d bytes", method, len(req.Body))
+	resp, err := conn.RoundTrip(req, n.Pool.ConnPool.Timeout)
+	finish := time.Now()
+
+	outItem.SentBytesCount = uint64(resp.SentBytes)
+	if !resp.SentTime.IsZero() {
+		outItem.SentTime = resp.SentTime.Sub(connected)
+		if !resp.FirstRead.IsZero() {
+			outItem.FirstByteTime = resp.FirstRead.Sub(resp.SentTime)
+			outItem.ReadTime = finish.Sub(resp.FirstRead)
+		}
+	}
+	outItem.RespBytesCount = uint64(resp.ReadLen)
+	outItem.Elapsed = finish.Sub(outItem.StartTime)
+
+	if err != nil {
+		outItem.EndWithError(err)
+		return outItem
+	}
+
+	outItem.RespBytes = resp.Bytes()
+	fillStatus(resp, outItem)
+	return outItem
+}
+
+func (n *Nib) frame(payload []byte) []byte {
+	if n.Framed {
+		return payload
+	}
+
+	body := make([]byte, prefixLen+len(payload))
+	binary.BigEndian.PutUint32(body[1:prefixLen], uint32(len(payload)))
+	copy(body[prefixLen:], payload)
+	return body
+}
+
+// splitAddress takes host from address, and method from either address path or the label
+func splitAddress(address string, label string) (*url.URL, string, error) {
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	parsed, err := url.Parse(address)
+	if err != nil {
+		return nil, "", errors.New(fmt.Sprintf("Failed to parse address '%s' as URL: %s", address, err))
+	}
+
+	_, unix := http.UnixScheme(parsed.Scheme)
+	method := parsed.Path
+	if unix || method == "" || method == "/" { // for unix sockets the path is the socket file
+		method = label
+	}
+	if !strings.HasPrefix(method, "/") {
+		method = "/" + method
+	}
+	if strings.Count(method, "/") != 2 {
+		return nil, "", errors.New(fmt.Sprintf("Method has to be in form of /package.Service/Method, got '%s'", method))
+	}
+
+	if unix {
+		return &url.URL{Scheme: parsed.Scheme, Path: parsed.Path}, method, nil
+	}
+
+	if parsed.Scheme != "https" {
+		parsed.Scheme = "http"
+	}
+
+	return &url.URL{Scheme: parsed.Scheme, Host: parsed.Host}, method, nil
+}
+
+func fillStatus(resp *http.H2Response, outItem *core.OutputItem) {
+	status, found := headerValue(resp, "grpc-status")
+	if !found {
+		outItem.Status = uint16(resp.Status)
+		outItem.Error = errors.New(fmt.Sprintf("Response has no grpc-status, HTTP status is %d", resp.Status))
+		return
+	}
+
+	code, err := strconv.Atoi(status)
+	if err != nil || code < 0 || StatusBase+code >= 999 {
+		outItem.Status = uint16(resp.Status)
+		outItem.Error = errors.New(fmt.Sprintf("Malformed grpc-status: %s", status))
+		return
+	}
+
+	if code == 0 {
+		outItem.Status = StatusOK
+	} else {
+		outItem.Status = uint16(StatusBase + code)
+		name := "CODE_" + status
+		if code < len(codeNames) {
+			name = codeNames[code]
+		}
+
+		msg, _ := headerValue(resp, "grpc-message")
+		if decoded, err := url.PathUnescape(msg); err == nil {
+			msg = decoded
+		}
+		outItem.Error = errors.New(strings.TrimSpace(fmt.Sprintf("gRPC %s: %s", name, msg)))
+	}
+}
+
+// headerValue looks into trailers first, then into headers for "trailers-only" responses
+func headerValue(resp *http.H2Response, name string) (string, bool) {
+	for _, h := range resp.Trailers {
+		if h.Name == name {
+			return h.Value, true
+		}
+	}
+
+	for _, h := range resp.Headers {
+		if h.Name == name {
+			return h.Value, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,126 @@
+package grpc
+
+import (
+	"bytes"
+	"encarno/pkg/core"
+	"encarno/pkg/http"
+	"encoding/binary"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"golang.org/x/net/http2/hpack"
+	"io"
+	gohttp "net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// echoes each request message back, failing for unknown methods
+func grpcHandler(w gohttp.ResponseWriter, r *gohttp.Request) {
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	if r.URL.Path != "/test.Echo/Say" {
+		w.Header().Set("Grpc-Status", "12")
+		w.Header().Set("Grpc-Message", "no%20such%20method")
+		return
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	_, _ = w.Write(body)
+	w.Header().Set("Grpc-Status", "0")
+}
+
+func TestNib(t *testing.T) {
+	srv := httptest.NewServer(h2c.NewHandler(gohttp.HandlerFunc(grpcHandler), &http2.Server{}))
+	defer srv.Close()
+
+	nib := Nib{
+		Pool: http.NewH2Pool(http.NewConnectionPool(1, 1*time.Second, core.TLSConf{})),
+	}
+
+	res := nib.Punch(&core.PayloadItem{
+		Address: srv.URL,
+		Label:   "test.Echo/Say",
+		Payload: []byte("\x0a\x03abc"),
+	})
+	if res.Error != nil {
+		t.Fatalf("Should not fail: %s", res.Error)
+	}
+	if res.Status != StatusOK {
+		t.Errorf("Wrong status: %d", res.Status)
+	}
+
+	res = nib.Punch(&core.PayloadItem{
+		Address: srv.URL + "/test.Echo/Missing",
+		Payload: []byte("\x0a\x03abc"),
+	})
+	if res.Status != StatusBase+12 {
+		t.Errorf("Wrong status: %d", res.Status)
+	}
+	if res.Error == nil || res.Error.Error() != "gRPC UNIMPLEMENTED: no such method" {
+		t.Errorf("Wrong error: %v", res.Error)
+	}
+}
+
+func TestNibFramed(t *testing.T) {
+	srv := httptest.NewServer(h2c.NewHandler(gohttp.HandlerFunc(grpcHandler), &http2.Server{}))
+	defer srv.Close()
+
+	nib := Nib{
+		Pool:   http.NewH2Pool(http.NewConnectionPool(1, 1*time.Second, core.TLSConf{})),
+		Framed: true,
+	}
+
+	payload := make([]byte, 0)
+	for _, msg := range []string{"one", "two", "three"} {
+		prefix := make([]byte, prefixLen)
+		binary.BigEndian.PutUint32(prefix[1:], uint32(len(msg)))
+		payload = append(payload, prefix...)
+		payload = append(payload, msg...)
+	}
+
+	res := nib.Punch(&core.PayloadItem{
+		Address: srv.URL + "/test.Echo/Say",
+		Payload: payload,
+	})
+	if res.Error != nil || res.Status != StatusOK {
+		t.Fatalf("Unexpected result: %d %v", res.Status, res.Error)
+	}
+	if !bytes.Contains(res.RespBytes, payload) {
+		t.Errorf("Messages were not echoed: %q", res.RespBytes)
+	}
+}
+
+func TestSplitAddress(t *testing.T) {
+	_, _, err := splitAddress("localhost:50051", "")
+	if err == nil {
+		t.Errorf("Should fail without method")
+	}
+
+	target, method, err := splitAddress("https://localhost:50051/pkg.Svc/Call", "label")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target.String() != "https://localhost:50051" || method != "/pkg.Svc/Call" {
+		t.Errorf("Wrong split: %s %s", target, method)
+	}
+
+	target, method, err = splitAddress("unix:///run/app.sock", "pkg.Svc/Call")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target.String() != "unix:///run/app.sock" || method != "/pkg.Svc/Call" {
+		t.Errorf("Wrong unix socket split: %s %s", target, method)
+	}
+}
+
+func TestFillStatus(t *testing.T) {
+	cases := map[string]uint16{"0": StatusOK, "14": StatusBase + 14, "42": StatusBase + 42, "-1": 200, "65536": 200, "399": 200}
+	for status, expected := range cases {
+		outItem := core.NewOutputItem()
+		fillStatus(&http.H2Response{Status: 200, Trailers: []hpack.HeaderField{{Name: "grpc-status", Value: status}}}, outItem)
+		if outItem.Status != expected || (outItem.Error == nil) != (status == "0") {
+			t.Errorf("Wrong result for grpc-status %s: %d %v", status, outItem.Status, outItem.Error)
+		}
+	}
+}
@@ -9,78 +9,61 @@ import (
 	"errors"
 	"fmt"
 	log "github.com/sirupsen/logrus"
-	"golang.org/x/exp/slices"
-	"io"
 	"net"
 	"net/url"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 )
 
+// ConnTimes is the breakdown of time spent to open new connection, it is zero for reused connections
+type ConnTimes struct {
+	DNS  time.Duration
+	Dial time.Duration
+	TLS  time.Duration
+}
+
+// Fill puts the breakdown into result item
+func (t ConnTimes) Fill(item *core.OutputItem) {
+	item.DNSTime = t.DNS
+	item.DialTime = t.Dial
+	item.TLSTime = t.TLS
+}
+
+// BufferedConn records what is read from connection, the reading happens in caller goroutine with deadlines set on it
 type BufferedConn struct {
 	net.Conn        // So that most methods are embedded
 	ReadRecordLimit int
-	ReadRecorded    bytes.Buffer
+	ReadRecorded    *bytes.Buffer // reused between requests, see TakeRecorded
 	ReadLen         int
 	FirstRead       time.Time
 	Err             error
 	Canceled        bool
-	readChunks      chan []byte
-	buf             []byte
 	closed          bool
-	loopDone        bool
 	mx              *sync.Mutex
 	BufReader       *bufio.Reader
+	Times           ConnTimes
+	Created         time.Time
+	Requests        int // number of times connection was taken from pool
+	idleSince       time.Time
+	released        bool   // guarded by host pool lock
+	onClose         func() // gives the slot back to pool
 }
 
 func newBufferedConn(c net.Conn) *BufferedConn {
 	conn := &BufferedConn{
 		Conn:            c,
 		ReadRecordLimit: -1,
-		buf:             make([]byte, 4096),
-		readChunks:      make(chan []byte),
+		ReadRecorded:    core.GetBuffer(),
 		mx:              new(sync.Mutex),
+		Created:         time.Now(),
+		Requests:        1,
 	}
 	conn.BufReader = bufio.NewReader(conn)
-
-	go conn.readLoop()
 	return conn
 }
 
-func (r *BufferedConn) readLoop() {
-	log.Debugf("Start reading loop")
-	for !r.Canceled {
-		n, err := r.Conn.Read(r.buf)
-		if err != nil {
-			r.setErr(err)
-			break
-		}
-
-		if r.FirstRead.IsZero() {
-			r.FirstRead = time.Now()
-		}
-
-		r.ReadLen += n
-		log.Debugf("Read %d/%d bytes, err: %v", n, r.ReadLen, err)
-
-		buf := make([]byte, n)
-		copy(buf, r.buf[:n])
-		if (r.ReadRecordLimit <= 0 || r.ReadLen <= r.ReadRecordLimit) && n > 0 {
-			_, err := r.ReadRecorded.Write(buf)
-			if err != nil {
-				panic(err)
-			}
-		}
-
-		r.readChunks <- buf
-	}
-	log.Debugf("Done reading loop")
-
-	r.Close()
-	r.loopDone = true
-}
-
 func (r *BufferedConn) setErr(err error) {
 	r.mx.Lock()
 	defer r.mx.Unlock()
@@ -95,48 +78,127 @@ func (r *BufferedConn) GetErr() error {
 }
 
 func (r *BufferedConn) Read(p []byte) (int, error) {
-	err := r.GetErr()
+	n, err := r.Conn.Read(p)
+	if n > 0 {
+		if r.FirstRead.IsZero() {
+			r.FirstRead = time.Now()
+		}
+
+		r.ReadLen += n
+		if r.ReadRecordLimit <= 0 || r.ReadLen <= r.ReadRecordLimit {
+			r.ReadRecorded.Write(p[:n])
+		}
+	}
+	log.Debugf("Read %d/%d bytes, err: %v", n, r.ReadLen, err)
+
+	if err != nil {
+		r.setErr(err) // connection is not reusable after any error, including timeouts
+	}
+	return n, err
+}
+
+// SendfileThreshold is the payload size from which unchanged payloads of cached input go to plain TCP via sendfile
+const SendfileThreshold = 64 * 1024
+
+// WritePayload writes payload, sending it straight from payload file when it is the unchanged file region
+func (r *BufferedConn) WritePayload(item *core.PayloadItem, payload []byte) (int, error) {
+	tcpConn, plain := r.Conn.(*net.TCPConn)
+	if !sendfileSupported || !plain || item.SourceFile == nil || len(payload) < SendfileThreshold ||
+		len(payload) != len(item.Payload) || &payload[0] != &item.Payload[0] {
+		return r.Write(payload)
+	}
+
+	raw, err := tcpConn.SyscallConn()
 	if err != nil {
 		return 0, err
 	}
 
-	buf := <-r.readChunks
-	n := copy(p, buf)
-	return n, nil
+	log.Debugf("Sending %d bytes from payload file offset %d", len(payload), item.SourceOffset)
+	return sendFile(raw, item.SourceFile, item.SourceOffset, len(payload))
 }
 
 func (r *BufferedConn) Close() {
 	log.Debugf("Closing buffered connection")
 	r.mx.Lock()
-	defer r.mx.Unlock()
 	r.Canceled = true
 
-	if !r.closed {
+	justClosed := !r.closed
+	if justClosed {
 		log.Debugf("Closing underlying connection: %p", r.Conn)
 		r.closed = true
-		close(r.readChunks)
 		err := r.Conn.Close()
 		if err != nil {
 			log.Warningf("Failed to close connection: %s", err)
 		}
 	}
+	onClose := r.onClose
+	r.mx.Unlock()
+
+	if justClosed && onClose != nil {
+		onClose()
+	}
 }
 
+// Reset prepares connection for reuse
 func (r *BufferedConn) Reset() {
+	r.Times = ConnTimes{}
 	r.ReadLen = 0
-	r.ReadRecorded.Truncate(0)
+	r.ReadRecorded.Reset()
 	r.FirstRead = time.Time{}
 }
 
-type ConnChan = chan *BufferedConn
+// TakeRecorded detaches recorded bytes for the result item, which gives the buffer back to pool once written out
+func (r *BufferedConn) TakeRecorded() *bytes.Buffer {
+	buf := r.ReadRecorded
+	r.ReadRecorded = core.GetBuffer()
+	return buf
+}
+
+// alive checks that idle connection was not closed by server, and has no unexpected data in it
+func (r *BufferedConn) alive() bool {
+	if r.BufReader.Buffered() > 0 {
+		return false
+	}
+
+	conn := r.Conn
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return true
+	}
+
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return false
+	}
+	return peekAlive(raw)
+}
+
+// ErrWaitTimeout means all MaxConnections to host were busy for the whole WaitTimeout
+var ErrWaitTimeout = errors.New("Timed out waiting for free connection in pool")
 
 type ConnPool struct {
-	Idle           map[string]ConnChan
-	MaxConnections int
+	MaxConnections int           // limit of open connections per host, zero means no limit
+	WaitTimeout    time.Duration // how long to wait for free connection when limit is reached
+	MaxIdleTime    time.Duration // idle connections are closed after this, zero means never
+	MaxLifetime    time.Duration // connections are not reused after this age, zero means no limit
+	MaxRequests    int           // connections are not reused after this many requests, zero means no limit
 	Timeout        time.Duration
-	plainDialer    *net.Dialer
-	tlsDialers     map[string]*tls.Dialer
+	hosts          map[string]*hostPool
+	plainDialer    *net.Dialer // for TCP, with socket options for source addresses
+	unixDialer     *net.Dialer
+	sources        *sourceDialers
+	tlsConfigs     map[string]*tls.Config
+	tlsBase        *tls.Config
+	clientCerts    []hostCert
 	TLSConf        core.TLSConf
+	NextProtos     []string
+	Resolver       *Resolver
+	Proxy          *url.URL // upstream proxy to tunnel connections through
+	ForwardPlain   bool     // send plain HTTP requests to proxy as is, instead of tunneling, see ProxyPayload
 	mxConn         *sync.Mutex
 	mxDialer       *sync.Mutex
 }
@@ -146,86 +208,205 @@ func NewConnectionPool(maxConnections int, timeout time.Duration, pconf core.TLS
 		Timeout: timeout,
 	}
 
+	tlsBase, clientCerts, err := newBaseTLSConfig(pconf)
+	if err != nil {
+		panic(err)
+	}
+
 	pool := &ConnPool{
 		plainDialer:    &plainDialer,
+		unixDialer:     &net.Dialer{Timeout: timeout},
+		sources:        &sourceDialers{},
+		tlsBase:        tlsBase,
+		clientCerts:    clientCerts,
+		NextProtos:     pconf.NextProtos,
 		TLSConf:        pconf,
-		tlsDialers:     map[string]*tls.Dialer{},
-		Idle:           map[string]ConnChan{},
+		tlsConfigs:     map[string]*tls.Config{},
+		hosts:          map[string]*hostPool{},
 		MaxConnections: maxConnections,
+		WaitTimeout:    timeout,
 		Timeout:        timeout,
+		Resolver:       NewResolver(core.DNSConf{}),
 		mxConn:         new(sync.Mutex),
 		mxDialer:       new(sync.Mutex),
 	}
 	return pool
 }
 
+// NewPoolFromConf creates connection pool with all the settings from protocol config
+func NewPoolFromConf(conf core.ProtoConf) *ConnPool {
+	pool := NewConnectionPool(conf.MaxConnections, conf.Timeout, conf.TLSConf)
+	pool.Resolver = NewResolver(conf.DNSConf)
+	pool.sources = newSourceDialers(pool.plainDialer, conf)
+	pool.Proxy = parseProxy(conf.Proxy)
+	if conf.ConnWaitTimeout > 0 {
+		pool.WaitTimeout = conf.ConnWaitTimeout
+	}
+	pool.MaxIdleTime = conf.MaxIdleTime
+	pool.MaxLifetime = conf.MaxLifetime
+	pool.MaxRequests = conf.MaxRequests
+	return pool
+}
+
 func (p *ConnPool) Get(hostname string, hostHint string) (*BufferedConn, error) {
-	// lazy initialize per-host pool
-	p.mxConn.Lock()
-	var ch ConnChan
-	if c, ok := p.Idle[hostname]; ok {
-		ch = c
-	} else {
-		log.Infof("Creating new connection pool for %s", hostname)
-		ch = make(ConnChan, p.MaxConnections)
-		p.Idle[hostname] = ch
-	}
-	p.mxConn.Unlock()
-
-	select {
-	case conn := <-ch:
-		err := conn.GetErr()
-		if err == io.EOF {
-			log.Debugf("Cannot reuse Idle connection: %v", err)
-		} else if err != nil {
-			log.Warningf("Cannot reuse Idle connection: %v", err)
-		} else {
-			log.Debugf("Reusing Idle connection to %s", hostname)
-			conn.Reset()
-			return conn, nil
-		}
-	default:
-		log.Debugf("No idle connections to reuse for %s", hostname)
+	host := p.host(hostname)
+
+	conn, err := host.acquire(p, time.Now())
+	if err != nil {
+		return nil, err
 	}
-	c, err := p.openConnection(hostname, hostHint)
-	if err == nil {
-		return newBufferedConn(c), nil
-	} else {
+
+	if conn != nil {
+		log.Debugf("Reusing Idle connection to %s", hostname)
+		conn.Reset()
+		conn.Requests++
+		return conn, nil
+	}
+
+	c, times, err := p.openConnection(hostname, hostHint)
+	if err != nil {
+		host.release(nil)
 		return nil, err
 	}
+
+	conn = newBufferedConn(c)
+	conn.Times = times
+	conn.onClose = func() {
+		host.release(conn)
+	}
+	return conn, nil
+}
+
+// Detach frees the pool slot taken by connection that is kept for a long session, like upgraded WebSocket,
+// such connection must not be returned into pool
+func (p *ConnPool) Detach(conn *BufferedConn) {
+	conn.mx.Lock()
+	onClose := conn.onClose
+	conn.onClose = nil
+	conn.mx.Unlock()
+
+	if onClose != nil {
+		onClose()
+	}
 }
 
-func (p *ConnPool) openConnection(hostname string, hint string) (net.Conn, error) {
+// host lazily initializes per-host pool
+func (p *ConnPool) host(hostname string) *hostPool {
+	p.mxConn.Lock()
+	defer p.mxConn.Unlock()
+	host, ok := p.hosts[hostname]
+	if !ok {
+		log.Infof("Creating new connection pool for %s", hostname)
+		host = &hostPool{mx: new(sync.Mutex)}
+		p.hosts[hostname] = host
+	}
+	return host
+}
+
+// IdleCount tells how many connections to host are waiting for reuse
+func (p *ConnPool) IdleCount(hostname string) int {
+	host := p.host(hostname)
+	host.mx.Lock()
+	defer host.mx.Unlock()
+	return len(host.idle)
+}
+
+func (p *ConnPool) openConnection(hostname string, hint string) (net.Conn, ConnTimes, error) {
 	log.Debugf("Opening new connection to %s", hostname)
+	times := ConnTimes{}
 
 	if !strings.Contains(hostname, "://") {
 		hostname = "http://" + hostname
 	}
 	parsed, err := url.Parse(hostname)
 	if err != nil {
-		return nil, errors.New(fmt.Sprintf("Failed to parse hostname '%s' as URL: %s", hostname, err))
+		return nil, times, errors.New(fmt.Sprintf("Failed to parse hostname '%s' as URL: %s", hostname, err))
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), p.Timeout)
 	defer cancel()
 
 	host, port := SplitHostPort(parsed.Host)
+	scheme, unix := UnixScheme(parsed.Scheme)
+	secure := scheme == "https" || scheme == "wss"
+	if port == "" && secure {
+		port = "443"
+	} else if port == "" {
+		port = "80"
+	}
 
-	if parsed.Scheme == "https" {
-		host = p.improveHost(host, port, "443")
+	var conn net.Conn
+	if unix {
+		start := time.Now()
+		log.Debugf("Dialing unix socket: %s", parsed.Path)
+		conn, err = p.unixDialer.DialContext(ctx, "unix", parsed.Path)
+		times.Dial = time.Now().Sub(start)
+		parsed.Host = "unix:" + parsed.Path // to have distinct TLS config cache key
+	} else if p.Proxy != nil {
+		conn, err = p.dialProxy(ctx, net.JoinHostPort(host, port), secure, &times)
+	} else {
+		conn, err = p.dialTCP(ctx, host, port, &times)
+	}
+	if err != nil {
+		return nil, times, err
+	}
 
-		if hint == "" {
-			hint = parsed.Host
-		}
+	if !secure {
+		return conn, times, nil
+	}
 
-		log.Debugf("Dialing TLS: %s", host)
-		return p.tlsDialerForHost(parsed.Host, hint).DialContext(ctx, "tcp", host)
-	} else {
-		host = p.improveHost(host, port, "80")
+	if hint == "" && unix {
+		hint = "localhost"
+	} else if hint == "" {
+		hint = parsed.Host
+	}
 
-		log.Debugf("Dialing plain: %s", host)
-		return p.plainDialer.DialContext(ctx, "tcp", host)
+	log.Debugf("Handshaking TLS: %s", hint)
+	tlsStart := time.Now()
+	tlsConn := tls.Client(conn, p.tlsConfigForHost(parsed.Host, hint))
+	err = tlsConn.HandshakeContext(ctx)
+	times.TLS = time.Now().Sub(tlsStart)
+	if err != nil {
+		_ = conn.Close()
+		return nil, times, err
 	}
+	return tlsConn, times, nil
+}
+
+func (p *ConnPool) dialTCP(ctx context.Context, host string, port string, times *ConnTimes) (net.Conn, error) {
+	start := time.Now()
+	ips, err := p.Resolver.Resolve(ctx, host)
+	times.DNS = time.Now().Sub(start)
+	if err != nil {
+		return nil, err
+	}
+
+	// the first address is the one picked by resolver, others are the fallback
+	var conn net.Conn
+	for _, ip := range ips {
+		addr := net.JoinHostPort(ip.String(), port)
+		log.Debugf("Dialing: %s", addr)
+		dialer := p.sources.pick(ip)
+		if dialer == nil {
+			dialer = p.plainDialer
+		}
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+		if err == nil {
+			break
+		}
+	}
+	times.Dial = time.Now().Sub(start) - times.DNS
+	return conn, err
+}
+
+// UnixScheme tells if address scheme is for unix socket, like "unix" or "https+unix", and returns the scheme to speak over it
+func UnixScheme(scheme string) (string, bool) {
+	if scheme == "unix" {
+		return "http", true
+	}
+
+	base := strings.TrimSuffix(scheme, "+unix")
+	return base, base != scheme
 }
 
 func SplitHostPort(host string) (string, string) {
@@ -240,58 +421,55 @@ func SplitHostPort(host string) (string, string) {
 	}
 }
 
-func (p *ConnPool) improveHost(host string, port string, defPort string) string {
-	if strings.Contains(host, ":") {
-		host = "[" + host + "]"
+func (p *ConnPool) Return(hostname string, conn *BufferedConn) {
+	if conn.GetErr() != nil {
+		go conn.Close()
+		return
+	}
+
+	if conn.Canceled {
+		return
 	}
 
-	if port != "" {
-		host = host + ":" + port
-	} else {
-		host = host + ":" + defPort
+	if !p.reusable(conn, time.Now()) {
+		log.Debugf("Connection to %s has reached its lifetime or requests limit", hostname)
+		go conn.Close()
+		return
 	}
-	return host
+
+	p.host(hostname).put(p, conn)
 }
 
-func (p *ConnPool) Return(hostname string, conn *BufferedConn) {
-	if !conn.Canceled {
-		idle := p.Idle[hostname] // can never fail in practice
-		idle <- conn
+func (p *ConnPool) reusable(conn *BufferedConn, now time.Time) bool {
+	if p.MaxRequests > 0 && conn.Requests >= p.MaxRequests {
+		return false
+	}
+
+	if p.MaxLifetime > 0 && now.Sub(conn.Created) >= p.MaxLifetime {
+		return false
 	}
+
+	return true
 }
 
-func (p *ConnPool) tlsDialerForHost(host string, hint string) *tls.Dialer {
+func (p *ConnPool) tlsConfigForHost(host string, hint string) *tls.Config {
 	p.mxDialer.Lock()
 	defer p.mxDialer.Unlock()
-	if obj, ok := p.tlsDialers[host]; ok {
+	if obj, ok := p.tlsConfigs[host]; ok {
 		return obj
 	}
 
-	tlsConfig := tls.Config{
-		ServerName:         hint,
-		CipherSuites:       []uint16{},
-		InsecureSkipVerify: p.TLSConf.InsecureSkipVerify,
-		MinVersion:         p.TLSConf.MinVersion,
-		MaxVersion:         p.TLSConf.MaxVersion,
+	if p.TLSConf.ServerName != "" {
+		hint = p.TLSConf.ServerName
 	}
 
-	for _, c := range tls.CipherSuites() {
-		if slices.Contains(p.TLSConf.TLSCipherSuites, c.Name) {
-			tlsConfig.CipherSuites = append(tlsConfig.CipherSuites, c.ID)
-		}
-	}
-	for _, c := range tls.InsecureCipherSuites() {
-		if slices.Contains(p.TLSConf.TLSCipherSuites, c.Name) {
-			tlsConfig.CipherSuites = append(tlsConfig.CipherSuites, c.ID)
-		}
-	}
-
-	dialer := &tls.Dialer{
-		NetDialer: p.plainDialer,
-		Config:    &tlsConfig,
-	}
+	sniHost, _ := SplitHostPort(hint)
+	tlsConfig := p.tlsBase.Clone()
+	tlsConfig.ServerName = sniHost
+	tlsConfig.NextProtos = p.NextProtos
+	tlsConfig.Certificates = certForHost(p.clientCerts, sniHost)
 
-	p.tlsDialers[host] = dialer
+	p.tlsConfigs[host] = tlsConfig
 
-	return dialer
+	return tlsConfig
 }
@@ -1,7 +1,16 @@
 package http
 
 import (
+	"bufio"
 	"encarno/pkg/core"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -24,3 +33,281 @@ func TestConnPool(t *testing.T) {
 		}
 	}
 }
+
+func TestConnTimes(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	address := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+	nib := Nib{ConnPool: NewConnectionPool(1, 1*time.Second, core.TLSConf{InsecureSkipVerify: true})}
+	item := core.PayloadItem{
+		Address: address,
+		Payload: []byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"),
+	}
+
+	res := nib.Punch(&item)
+	if res.Error != nil {
+		t.Fatalf("Should not fail: %s", res.Error)
+	}
+	if res.DNSTime <= 0 || res.DialTime <= 0 || res.TLSTime <= 0 {
+		t.Errorf("Breakdown is not filled: %v %v %v", res.DNSTime, res.DialTime, res.TLSTime)
+	}
+	if res.DNSTime+res.DialTime+res.TLSTime > res.ConnectTime {
+		t.Errorf("Breakdown exceeds connect time: %v", res.ConnectTime)
+	}
+
+	res = nib.Punch(&item)
+	if res.Error != nil {
+		t.Fatalf("Should not fail: %s", res.Error)
+	}
+	if res.DNSTime != 0 || res.DialTime != 0 || res.TLSTime != 0 {
+		t.Errorf("Reused connection should have no breakdown: %v %v %v", res.DNSTime, res.DialTime, res.TLSTime)
+	}
+}
+
+func TestConnPoolLimit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	addr := srv.URL
+
+	pool := NewConnectionPool(1, 1*time.Second, core.TLSConf{})
+	pool.WaitTimeout = 50 * time.Millisecond
+	first, err := pool.Get(addr, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = pool.Get(addr, "")
+	if err != ErrWaitTimeout {
+		t.Fatalf("Should time out waiting, got: %v", err)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		pool.Return(addr, first)
+	}()
+	second, err := pool.Get(addr, "")
+	if err != nil || second != first {
+		t.Fatalf("Should get returned connection: %v", err)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		second.Close()
+	}()
+	third, err := pool.Get(addr, "")
+	if err != nil || third == first {
+		t.Fatalf("Should open new connection instead of closed one: %v", err)
+	}
+	third.Close()
+}
+
+func TestConnPoolExpiry(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	addr := srv.URL
+
+	pool := NewPoolFromConf(core.ProtoConf{
+		MaxConnections: 1,
+		Timeout:        1 * time.Second,
+		MaxRequests:    2,
+		MaxIdleTime:    50 * time.Millisecond,
+	})
+
+	first, _ := pool.Get(addr, "")
+	pool.Return(addr, first)
+	second, _ := pool.Get(addr, "")
+	if second != first || second.Requests != 2 {
+		t.Fatalf("Should reuse connection")
+	}
+
+	pool.Return(addr, second)
+	if pool.IdleCount(addr) != 0 {
+		t.Errorf("Connection should not be reused after MaxRequests")
+	}
+
+	third, err := pool.Get(addr, "")
+	if err != nil || third == first {
+		t.Fatalf("Should open new connection: %v", err)
+	}
+
+	pool.Return(addr, third)
+	time.Sleep(60 * time.Millisecond)
+	fourth, err := pool.Get(addr, "")
+	if err != nil || fourth == third {
+		t.Fatalf("Idle connection should expire: %v", err)
+	}
+	fourth.Close()
+}
+
+func TestConnPoolIdleExpiry(t *testing.T) {
+	closed := make(chan struct{}, 10)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateClosed {
+			closed <- struct{}{}
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+	addr := srv.URL
+
+	pool := NewPoolFromConf(core.ProtoConf{
+		Timeout:     1 * time.Second,
+		MaxIdleTime: 50 * time.Millisecond,
+	})
+
+	oldest, _ := pool.Get(addr, "")
+	newest, _ := pool.Get(addr, "")
+	pool.Return(addr, oldest)
+	time.Sleep(60 * time.Millisecond)
+	pool.Return(addr, newest)
+
+	if pool.IdleCount(addr) != 1 {
+		t.Errorf("Expired connection at the bottom of idle stack should be dropped, idle: %d", pool.IdleCount(addr))
+	}
+
+	select {
+	case <-closed:
+	case <-time.After(1 * time.Second):
+		t.Fatalf("Expired idle connection should be closed")
+	}
+
+	conn, err := pool.Get(addr, "")
+	if err != nil || conn != newest {
+		t.Fatalf("Should reuse connection that has not expired: %v", err)
+	}
+	conn.Close()
+}
+
+func TestUnixSocket(t *testing.T) {
+	for _, secure := range []bool{false, true} {
+		sock := filepath.Join(t.TempDir(), "test.sock")
+		ln, err := net.Listen("unix", sock)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("via socket " + r.Host))
+		}))
+		srv.Listener = ln
+		address := "unix://" + sock
+		if secure {
+			srv.StartTLS()
+			address = "https+unix://" + sock
+		} else {
+			srv.Start()
+		}
+
+		nib := Nib{ConnPool: NewConnectionPool(1, 1*time.Second, core.TLSConf{InsecureSkipVerify: true})}
+		item := core.PayloadItem{
+			Address: address,
+			Payload: []byte("GET / HTTP/1.1\r\nHost: sidecar\r\n\r\n"),
+		}
+
+		res := nib.Punch(&item)
+		if res.Error != nil || !strings.HasSuffix(string(res.RespBytes), "via socket sidecar") {
+			t.Errorf("Failed for %s: %v\n%s", address, res.Error, res.RespBytes)
+		}
+		if res.DialTime <= 0 || (secure && res.TLSTime <= 0) {
+			t.Errorf("Times are not filled for %s", address)
+		}
+		srv.Close()
+	}
+}
+
+func TestIdleClosedByServer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = http.ReadRequest(bufio.NewReader(conn))
+			_, _ = conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"))
+			_ = conn.Close() // without telling client in headers
+		}
+	}()
+
+	nib := Nib{ConnPool: NewConnectionPool(1, 1*time.Second, core.TLSConf{})}
+	for x := 0; x < 3; x++ {
+		item := core.PayloadItem{
+			Address: ln.Addr().String(),
+			Payload: []byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"),
+		}
+		time.Sleep(10 * time.Millisecond) // let FIN arrive
+		res := nib.Punch(&item)
+		if res.Error != nil || string(res.RespBytes) != "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok" {
+			t.Errorf("Should not reuse closed connection: %v\n%s", res.Error, res.RespBytes)
+		}
+		if res.DialTime == 0 {
+			t.Errorf("Should open new connection each time")
+		}
+	}
+}
+
+func TestSendfile(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_, _ = w.Write([]byte(fmt.Sprintf("got %d bytes ending with %s", len(body), body[len(body)-3:])))
+	}))
+	defer srv.Close()
+
+	body := strings.Repeat("x", 2*SendfileThreshold) + "end"
+	payload := fmt.Sprintf("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+
+	file, err := os.Create(filepath.Join(t.TempDir(), "payload.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	_, _ = file.WriteString("{\"plen\": 1}\n" + payload)
+
+	item := core.PayloadItem{
+		Address:      srv.URL,
+		Payload:      []byte(payload),
+		SourceFile:   file,
+		SourceOffset: 12,
+	}
+
+	nib := Nib{ConnPool: NewConnectionPool(1, 1*time.Second, core.TLSConf{})}
+	for x := 0; x < 2; x++ { // second time over reused connection
+		res := nib.Punch(&item)
+		if res.Error != nil {
+			t.Fatal(res.Error)
+		}
+
+		if res.SentBytesCount != uint64(len(payload)) || !strings.HasSuffix(string(res.RespBytes), "got 131075 bytes ending with end") {
+			t.Errorf("Wrong result: %d sent, response: %s", res.SentBytesCount, res.RespBytes)
+		}
+	}
+}
+
+func BenchmarkNibKeepAlive(b *testing.B) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	nib := Nib{ConnPool: NewConnectionPool(1, 1*time.Second, core.TLSConf{})}
+	payload := []byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for x := 0; x < b.N; x++ {
+		item := core.PayloadItem{Address: srv.URL, Payload: payload}
+		res := nib.Punch(&item)
+		if res.Error != nil {
+			b.Fatal(res.Error)
+		}
+		res.Release()
+	}
+}
@@ -0,0 +1,78 @@
+package http
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encarno/pkg/core"
+	"errors"
+	"fmt"
+	"github.com/andybalholm/brotli"
+	"io"
+	"strings"
+)
+
+// Options is the driver-specific section of protocol config for http and http2 drivers
+type Options struct {
+	DecodeBody bool // run extractors and asserts over decompressed and de-chunked body
+}
+
+func driverOptions() interface{} {
+	return &Options{}
+}
+
+// decodeBody undoes Content-Encoding, codings are listed in the order they were applied
+func decodeBody(encoding string, body []byte) ([]byte, error) {
+	codings := strings.Split(encoding, ",")
+	for n := len(codings) - 1; n >= 0; n-- {
+		coding := strings.ToLower(strings.TrimSpace(codings[n]))
+		if coding == "" || coding == "identity" || len(body) == 0 {
+			continue
+		}
+
+		var reader io.Reader
+		var err error
+		switch coding {
+		case "gzip", "x-gzip":
+			reader, err = gzip.NewReader(bytes.NewReader(body))
+		case "deflate": // should be zlib-wrapped, but some servers send raw deflate
+			reader, err = zlib.NewReader(bytes.NewReader(body))
+			if err != nil {
+				reader, err = flate.NewReader(bytes.NewReader(body)), nil
+			}
+		case "br":
+			reader = brotli.NewReader(bytes.NewReader(body))
+		default:
+			return body, errors.New(fmt.Sprintf("Unsupported content encoding: %s", coding))
+		}
+
+		if err == nil {
+			body, err = io.ReadAll(reader)
+		}
+
+		if err != nil {
+			return body, errors.New(fmt.Sprintf("Failed to decode %s response body: %s", coding, err))
+		}
+	}
+	return body, nil
+}
+
+// decodedView puts response head and decoded body together, the raw body is used if decoding fails
+func decodedView(raw []byte, encoding string, body []byte) (*bytes.Buffer, error) {
+	head, _, found := bytes.Cut(raw, []byte("\r\n\r\n"))
+	if !found {
+		head, _, _ = bytes.Cut(raw, []byte("\n\n"))
+	}
+
+	view := core.GetBuffer()
+	view.Write(head)
+	view.WriteString("\r\n\r\n")
+
+	decoded, err := decodeBody(encoding, body)
+	if err != nil {
+		decoded = body
+	}
+	view.Write(decoded)
+	return view, err
+}
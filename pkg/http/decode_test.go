@@ -0,0 +1,104 @@
+package http
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encarno/pkg/core"
+	"github.com/andybalholm/brotli"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func compress(t *testing.T, coding string, data []byte) []byte {
+	buf := bytes.Buffer{}
+	var w io.WriteCloser
+	switch coding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	case "rawdeflate":
+		w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+	case "br":
+		w = brotli.NewWriter(&buf)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	_ = w.Close()
+	return buf.Bytes()
+}
+
+func TestDecodeBody(t *testing.T) {
+	data := []byte(`{"status": "ok"}`)
+	cases := map[string][]byte{
+		"":                data,
+		"identity":        data,
+		"gzip":            compress(t, "gzip", data),
+		"deflate":         compress(t, "deflate", data),
+		"br":              compress(t, "br", data),
+		"gzip, br":        compress(t, "br", compress(t, "gzip", data)),
+		"Deflate ":        compress(t, "rawdeflate", data),
+		"x-gzip,identity": compress(t, "gzip", data),
+	}
+
+	for encoding, body := range cases {
+		res, err := decodeBody(encoding, body)
+		if err != nil || !bytes.Equal(res, data) {
+			t.Errorf("Failed to decode '%s': %v, %s", encoding, err, res)
+		}
+	}
+
+	if _, err := decodeBody("compress", data); err == nil || err.Error() != "Unsupported content encoding: compress" {
+		t.Errorf("Should fail on unsupported encoding: %v", err)
+	}
+
+	if _, err := decodeBody("gzip", data); err == nil {
+		t.Errorf("Should fail on broken body")
+	}
+}
+
+func TestNibDecodeBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.(http.Flusher).Flush() // makes it chunked
+		gz := gzip.NewWriter(w)
+		_, _ = gz.Write([]byte(`{"padding": "` + strings.Repeat("abc", 1000) + `", "token": "abc123"}`))
+		_ = gz.Close()
+	}))
+	defer srv.Close()
+
+	item := core.PayloadItem{
+		Address: srv.URL,
+		Payload: []byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"),
+		Asserts: []*core.AssertItem{{Re: &core.RegexpProxy{Regexp: regexp.MustCompile(`"token": "\w+"`)}}},
+	}
+
+	for _, decode := range []bool{false, true} {
+		nib := Nib{ConnPool: NewConnectionPool(1, 1*time.Second, core.TLSConf{}), DecodeBody: decode}
+		res := nib.Punch(&item)
+		if res.Error != nil {
+			t.Fatal(res.Error)
+		}
+		res.Assert(item.Asserts)
+
+		if res.RespBytesCount != uint64(len(res.RespBytes)) || !strings.Contains(string(res.RespBytes), "Transfer-Encoding: chunked") {
+			t.Errorf("Raw response should be kept: %d, %s", res.RespBytesCount, res.RespBytes)
+		}
+
+		view := string(res.MatchBytes)
+		if decode && (res.Error != nil || !strings.HasPrefix(view, "HTTP/1.1 200 OK\r\n") || !strings.HasSuffix(view, "\r\n\r\n{\"padding\": \""+strings.Repeat("abc", 1000)+`", "token": "abc123"}`)) {
+			t.Errorf("Should assert over decoded body: %v\n%s", res.Error, res.MatchBytes)
+		} else if !decode && res.Error == nil {
+			t.Errorf("Should not match compressed body")
+		}
+		res.Release()
+	}
+}
@@ -0,0 +1,697 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const h2ClientPreface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+const h2FrameHeaderLen = 9
+const h2DefaultWindow = 65535
+const h2DefaultMaxStreams = 100
+
+// H2Request is a transport-level HTTP/2 request, pseudo-headers included
+type H2Request struct {
+	Headers     []hpack.HeaderField
+	Body        []byte
+	RecordLimit int // same meaning as BufferedConn.ReadRecordLimit
+}
+
+// H2Response is what has been received for a single stream
+type H2Response struct {
+	Status    int
+	Headers   []hpack.HeaderField
+	Trailers  []hpack.HeaderField
+	Body      bytes.Buffer
+	SentBytes int
+	ReadLen   int
+	SentTime  time.Time
+	FirstRead time.Time
+}
+
+// Bytes renders response into HTTP/1.1-alike text, so the same regexes work for both drivers
+func (resp *H2Response) Bytes() []byte {
+	return resp.render(resp.Body.Bytes())
+}
+
+// Header gives the first value of response header with lowercase name
+func (resp *H2Response) Header(name string) string {
+	for _, h := range resp.Headers {
+		if h.Name == name {
+			return h.Value
+		}
+	}
+	return ""
+}
+
+func (resp *H2Response) render(body []byte) []byte {
+	buf := bytes.Buffer{}
+	buf.WriteString("HTTP/2.0 " + strconv.Itoa(resp.Status) + "\r\n")
+	for _, h := range resp.Headers {
+		buf.WriteString(http.CanonicalHeaderKey(h.Name) + ": " + h.Value + "\r\n")
+	}
+	buf.WriteString("\r\n")
+	buf.Write(body)
+	for _, h := range resp.Trailers {
+		buf.WriteString("\r\n" + http.CanonicalHeaderKey(h.Name) + ": " + h.Value)
+	}
+	return buf.Bytes()
+}
+
+type h2Stream struct {
+	id         uint32
+	resp       *H2Response
+	limit      int
+	sendWindow int32
+	gotHeaders bool
+	done       chan struct{}
+	err        error
+}
+
+func (s *h2Stream) finish(err error) {
+	if s.done == nil {
+		return
+	}
+	s.err = err
+	close(s.done)
+	s.done = nil
+}
+
+// H2Conn multiplexes concurrent streams over single connection, reading frames in own goroutine
+type H2Conn struct {
+	conn         net.Conn
+	framer       *http2.Framer
+	writer       *bufio.Writer
+	henc         *hpack.Encoder
+	hbuf         bytes.Buffer
+	wmx          *sync.Mutex // guards framer writes and header encoder
+	mx           *sync.Mutex // guards everything below
+	cond         *sync.Cond  // signals changes of flow-control windows
+	streams      map[uint32]*h2Stream
+	nextID       uint32
+	active       int
+	maxStreams   int
+	maxFrameSize int
+	sendWindow   int32
+	initWindow   int32
+	goAway       bool
+	draining     bool // no new streams, closed once the last active stream is released
+	Err          error
+}
+
+func newH2Conn(c net.Conn) (*H2Conn, error) {
+	if tc, ok := c.(*tls.Conn); ok {
+		proto := tc.ConnectionState().NegotiatedProtocol
+		if proto != http2.NextProtoTLS {
+			_ = c.Close()
+			return nil, errors.New(fmt.Sprintf("Server did not negotiate HTTP/2 via ALPN, got '%s'", proto))
+		}
+	}
+
+	h2 := &H2Conn{
+		conn:         c,
+		writer:       bufio.NewWriter(c),
+		wmx:          new(sync.Mutex),
+		mx:           new(sync.Mutex),
+		streams:      map[uint32]*h2Stream{},
+		nextID:       1,
+		maxStreams:   h2DefaultMaxStreams,
+		maxFrameSize: 16384,
+		sendWindow:   h2DefaultWindow,
+		initWindow:   h2DefaultWindow,
+	}
+	h2.cond = sync.NewCond(h2.mx)
+	h2.henc = hpack.NewEncoder(&h2.hbuf)
+	h2.framer = http2.NewFramer(h2.writer, c)
+	h2.framer.ReadMetaHeaders = hpack.NewDecoder(4096, nil)
+	h2.framer.MaxHeaderListSize = 10 << 20
+
+	if _, err := h2.writer.WriteString(h2ClientPreface); err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+
+	err := h2.framer.WriteSettings(
+		http2.Setting{ID: http2.SettingEnablePush, Val: 0},
+		http2.Setting{ID: http2.SettingInitialWindowSize, Val: 4 << 20},
+	)
+	if err == nil {
+		err = h2.framer.WriteWindowUpdate(0, (4<<20)-h2DefaultWindow)
+	}
+	if err == nil {
+		err = h2.writer.Flush()
+	}
+	if err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+
+	go h2.readLoop()
+	return h2, nil
+}
+
+// reserve takes a stream slot if connection can accept one more stream
+func (c *H2Conn) reserve() (bool, error) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if c.maxStreams == 0 && c.Err == nil && !c.goAway {
+		return false, errNoStreams
+	}
+	if c.Err != nil || c.goAway || c.draining || c.active >= c.maxStreams {
+		return false, nil
+	}
+	c.active++
+	return true, nil
+}
+
+func (c *H2Conn) release() {
+	c.mx.Lock()
+	c.active--
+	idle := c.draining && c.active == 0
+	c.mx.Unlock()
+	if idle {
+		c.Close()
+	}
+}
+
+// drain stops opening streams on connection, streams in flight are let to complete before it is closed
+func (c *H2Conn) drain() {
+	c.mx.Lock()
+	c.draining = true
+	idle := c.active == 0
+	c.mx.Unlock()
+	if idle {
+		c.Close()
+	}
+}
+
+func (c *H2Conn) Alive() bool {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.Err == nil && !c.goAway
+}
+
+// RoundTrip sends request on a new stream and waits for the stream to end
+func (c *H2Conn) RoundTrip(req *H2Request, timeout time.Duration) (*H2Response, error) {
+	resp := &H2Response{}
+	stream := &h2Stream{
+		resp:  resp,
+		limit: req.RecordLimit,
+		done:  make(chan struct{}),
+	}
+	done := stream.done
+	timeoutErr := errors.New(fmt.Sprintf("HTTP/2 stream timed out after %s", timeout))
+	deadline := time.Now().Add(timeout)
+
+	if err := c.writeRequest(stream, req, deadline); err != nil {
+		if err == errWindowTimeout {
+			err = timeoutErr
+		}
+
+		if stream.id != 0 { // was opened
+			c.cancel(stream, err)
+		}
+		return resp, err
+	}
+	resp.SentTime = time.Now()
+
+	select {
+	case <-done:
+	case <-time.After(time.Until(deadline)):
+		c.cancel(stream, timeoutErr)
+		<-done // read loop may have finished the stream first, its result is safe to read only after that
+	}
+
+	return resp, stream.err
+}
+
+// errWindowTimeout is returned when request deadline passes while waiting for flow-control window to send body
+var errNoStreams = errors.New("HTTP/2 server does not allow any streams (SETTINGS_MAX_CONCURRENT_STREAMS is 0)")
+
+var errWindowTimeout = errors.New("timed out waiting for HTTP/2 flow-control window")
+
+func (c *H2Conn) writeRequest(stream *h2Stream, req *H2Request, deadline time.Time) error {
+	c.wmx.Lock()
+	c.hbuf.Reset()
+	for _, f := range req.Headers {
+		if err := c.henc.WriteField(f); err != nil {
+			c.wmx.Unlock()
+			return err
+		}
+	}
+
+	// stream IDs must be allocated in the order of HEADERS frames on the wire
+	c.mx.Lock()
+	if c.Err != nil {
+		c.mx.Unlock()
+		c.wmx.Unlock()
+		return c.Err
+	}
+	stream.id = c.nextID
+	c.nextID += 2
+	stream.sendWindow = c.initWindow
+	c.streams[stream.id] = stream
+	maxFrame := c.maxFrameSize
+	c.mx.Unlock()
+
+	block := c.hbuf.Bytes()
+	endStream := len(req.Body) == 0
+	first := true
+	for first || len(block) > 0 {
+		chunk := block
+		if len(chunk) > maxFrame {
+			chunk = chunk[:maxFrame]
+		}
+		block = block[len(chunk):]
+
+		var err error
+		if first {
+			err = c.framer.WriteHeaders(http2.HeadersFrameParam{
+				StreamID:      stream.id,
+				BlockFragment: chunk,
+				EndStream:     endStream,
+				EndHeaders:    len(block) == 0,
+			})
+			first = false
+		} else {
+			err = c.framer.WriteContinuation(stream.id, len(block) == 0, chunk)
+		}
+		stream.resp.SentBytes += h2FrameHeaderLen + len(chunk)
+		if err != nil {
+			c.wmx.Unlock()
+			c.fail(err)
+			return err
+		}
+	}
+
+	if err := c.writer.Flush(); err != nil {
+		c.wmx.Unlock()
+		c.fail(err)
+		return err
+	}
+	c.wmx.Unlock()
+
+	return c.writeBody(stream, req.Body, deadline)
+}
+
+func (c *H2Conn) writeBody(stream *h2Stream, body []byte, deadline time.Time) error {
+	if len(body) > 0 { // waiting for window has to end at deadline
+		wake := time.AfterFunc(time.Until(deadline), func() {
+			c.mx.Lock()
+			c.cond.Broadcast()
+			c.mx.Unlock()
+		})
+		defer wake.Stop()
+	}
+
+	for len(body) > 0 {
+		c.mx.Lock()
+		for c.Err == nil && stream.done != nil && (c.sendWindow <= 0 || stream.sendWindow <= 0) {
+			if !time.Now().Before(deadline) {
+				c.mx.Unlock()
+				return errWindowTimeout
+			}
+			c.cond.Wait()
+		}
+		if c.Err != nil {
+			c.mx.Unlock()
+			return c.Err
+		}
+		if stream.done == nil {
+			c.mx.Unlock()
+			return stream.err
+		}
+
+		n := len(body)
+		if n > c.maxFrameSize {
+			n = c.maxFrameSize
+		}
+		if int32(n) > c.sendWindow {
+			n = int(c.sendWindow)
+		}
+		if int32(n) > stream.sendWindow {
+			n = int(stream.sendWindow)
+		}
+		c.sendWindow -= int32(n)
+		stream.sendWindow -= int32(n)
+		c.mx.Unlock()
+
+		chunk := body[:n]
+		body = body[n:]
+
+		c.wmx.Lock()
+		err := c.framer.WriteData(stream.id, len(body) == 0, chunk)
+		if err == nil {
+			err = c.writer.Flush()
+		}
+		c.wmx.Unlock()
+		stream.resp.SentBytes += h2FrameHeaderLen + n
+
+		if err != nil {
+			c.fail(err)
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *H2Conn) cancel(stream *h2Stream, err error) {
+	c.mx.Lock()
+	if _, ok := c.streams[stream.id]; !ok {
+		c.mx.Unlock()
+		return
+	}
+	delete(c.streams, stream.id)
+	stream.finish(err)
+	c.cond.Broadcast()
+	c.mx.Unlock()
+
+	c.wmx.Lock()
+	defer c.wmx.Unlock()
+	if c.framer.WriteRSTStream(stream.id, http2.ErrCodeCancel) == nil {
+		_ = c.writer.Flush()
+	}
+}
+
+func (c *H2Conn) fail(err error) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if c.Err == nil {
+		c.Err = err
+	}
+
+	for id, s := range c.streams {
+		s.finish(err)
+		delete(c.streams, id)
+	}
+	c.cond.Broadcast()
+}
+
+func (c *H2Conn) Close() {
+	c.fail(io.ErrClosedPipe)
+	if err := c.conn.Close(); err != nil {
+		log.Debugf("Failed to close HTTP/2 connection: %s", err)
+	}
+}
+
+func (c *H2Conn) readLoop() {
+	log.Debugf("Start HTTP/2 reading loop")
+	for {
+		frame, err := c.framer.ReadFrame()
+		if err != nil {
+			log.Debugf("HTTP/2 connection read failed: %s", err)
+			c.fail(err)
+			break
+		}
+
+		if err := c.processFrame(frame); err != nil {
+			c.fail(err)
+			break
+		}
+	}
+	_ = c.conn.Close()
+	log.Debugf("Done HTTP/2 reading loop")
+}
+
+func (c *H2Conn) processFrame(frame http2.Frame) error {
+	switch f := frame.(type) {
+	case *http2.SettingsFrame:
+		return c.processSettings(f)
+	case *http2.PingFrame:
+		if !f.IsAck() {
+			return c.writeLocked(func() error {
+				return c.framer.WritePing(true, f.Data)
+			})
+		}
+	case *http2.WindowUpdateFrame:
+		c.mx.Lock()
+		if f.StreamID == 0 {
+			c.sendWindow += int32(f.Increment)
+		} else if s, ok := c.streams[f.StreamID]; ok {
+			s.sendWindow += int32(f.Increment)
+		}
+		c.cond.Broadcast()
+		c.mx.Unlock()
+	case *http2.GoAwayFrame:
+		log.Debugf("Got GOAWAY from server, last stream: %d, code: %s", f.LastStreamID, f.ErrCode)
+		c.mx.Lock()
+		c.goAway = true
+		for id, s := range c.streams {
+			if id > f.LastStreamID {
+				s.finish(errors.New(fmt.Sprintf("Stream was refused by GOAWAY: %s", f.ErrCode)))
+				delete(c.streams, id)
+			}
+		}
+		c.cond.Broadcast()
+		c.mx.Unlock()
+	case *http2.MetaHeadersFrame:
+		c.processHeaders(f)
+	case *http2.DataFrame:
+		return c.processData(f)
+	case *http2.RSTStreamFrame:
+		c.mx.Lock()
+		if s, ok := c.streams[f.StreamID]; ok {
+			delete(c.streams, f.StreamID)
+			s.finish(errors.New(fmt.Sprintf("Stream was reset by server: %s", f.ErrCode)))
+		}
+		c.cond.Broadcast()
+		c.mx.Unlock()
+	default:
+		log.Debugf("Ignoring HTTP/2 frame: %s", f.Header().Type)
+	}
+	return nil
+}
+
+func (c *H2Conn) processSettings(f *http2.SettingsFrame) error {
+	if f.IsAck() {
+		return nil
+	}
+
+	c.mx.Lock()
+	err := f.ForeachSetting(func(s http2.Setting) error {
+		switch s.ID {
+		case http2.SettingMaxConcurrentStreams:
+			c.maxStreams = int(s.Val)
+		case http2.SettingMaxFrameSize:
+			c.maxFrameSize = int(s.Val)
+		case http2.SettingInitialWindowSize:
+			delta := int32(s.Val) - c.initWindow
+			c.initWindow = int32(s.Val)
+			for _, st := range c.streams {
+				st.sendWindow += delta
+			}
+		}
+		return nil
+	})
+	c.cond.Broadcast()
+	c.mx.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return c.writeLocked(func() error {
+		return c.framer.WriteSettingsAck()
+	})
+}
+
+func (c *H2Conn) processHeaders(f *http2.MetaHeadersFrame) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	s, ok := c.streams[f.StreamID]
+	if !ok {
+		return
+	}
+
+	resp := s.resp
+	if resp.FirstRead.IsZero() {
+		resp.FirstRead = time.Now()
+	}
+	resp.ReadLen += h2FrameHeaderLen + int(f.Length)
+
+	if s.gotHeaders {
+		resp.Trailers = append(resp.Trailers, f.RegularFields()...)
+	} else {
+		status := f.PseudoValue("status")
+		if len(status) > 0 && status[0] == '1' { // informational responses are skipped
+			return
+		}
+		s.gotHeaders = true
+		resp.Status, _ = strconv.Atoi(status)
+		resp.Headers = f.RegularFields()
+	}
+
+	if f.StreamEnded() {
+		delete(c.streams, s.id)
+		s.finish(nil)
+	}
+}
+
+func (c *H2Conn) processData(f *http2.DataFrame) error {
+	c.mx.Lock()
+	s, ok := c.streams[f.StreamID]
+	if ok {
+		resp := s.resp
+		if resp.FirstRead.IsZero() {
+			resp.FirstRead = time.Now()
+		}
+		resp.ReadLen += h2FrameHeaderLen + int(f.Length)
+
+		data := f.Data()
+		if s.limit <= 0 || resp.Body.Len() < s.limit {
+			resp.Body.Write(data)
+		}
+
+		if f.StreamEnded() {
+			delete(c.streams, s.id)
+			s.finish(nil)
+		}
+	}
+	c.mx.Unlock()
+
+	if f.Length == 0 {
+		return nil
+	}
+
+	// we do not limit the server, replenishing windows right away
+	return c.writeLocked(func() error {
+		err := c.framer.WriteWindowUpdate(0, f.Length)
+		if err == nil && ok && !f.StreamEnded() {
+			err = c.framer.WriteWindowUpdate(f.StreamID, f.Length)
+		}
+		return err
+	})
+}
+
+func (c *H2Conn) writeLocked(fn func() error) error {
+	c.wmx.Lock()
+	defer c.wmx.Unlock()
+	err := fn()
+	if err == nil {
+		err = c.writer.Flush()
+	}
+	return err
+}
+
+type h2HostConns struct {
+	conns   []*H2Conn
+	dialing chan struct{} // closed when new connection is dialed, nil if none is being dialed
+	mx      *sync.Mutex
+}
+
+// H2Pool keeps multiplexed connections per host, opening them via ConnPool
+type H2Pool struct {
+	ConnPool *ConnPool
+	hosts    map[string]*h2HostConns
+	mx       *sync.Mutex
+}
+
+func NewH2Pool(pool *ConnPool) *H2Pool {
+	hasH2 := false
+	for _, proto := range pool.NextProtos {
+		hasH2 = hasH2 || proto == http2.NextProtoTLS
+	}
+	if !hasH2 { // configured protocols are kept, server may still prefer them
+		pool.NextProtos = append([]string{http2.NextProtoTLS}, pool.NextProtos...)
+	}
+	return &H2Pool{
+		ConnPool: pool,
+		hosts:    map[string]*h2HostConns{},
+		mx:       new(sync.Mutex),
+	}
+}
+
+// Get returns connection with a reserved stream slot, caller has to call Release after the stream is done.
+// Connection times are non-zero only when new connection was opened for this stream.
+func (p *H2Pool) Get(hostname string, hostHint string) (*H2Conn, ConnTimes, error) {
+	p.mx.Lock()
+	host, ok := p.hosts[hostname]
+	if !ok {
+		log.Infof("Creating new HTTP/2 connection pool for %s", hostname)
+		host = &h2HostConns{mx: new(sync.Mutex)}
+		p.hosts[hostname] = host
+	}
+	p.mx.Unlock()
+
+	for {
+		host.mx.Lock()
+		if conn, err := host.reserve(hostname); conn != nil || err != nil {
+			host.mx.Unlock()
+			return conn, ConnTimes{}, err
+		}
+
+		if wait := host.dialing; wait != nil { // its streams may be enough for us too
+			host.mx.Unlock()
+			<-wait
+			continue
+		}
+
+		dialing := make(chan struct{})
+		host.dialing = dialing
+		host.mx.Unlock()
+
+		log.Debugf("No HTTP/2 connections with free streams for %s", hostname)
+		conn, times, err := p.dial(hostname, hostHint)
+
+		host.mx.Lock()
+		if err == nil {
+			_, _ = conn.reserve() // server settings are not received yet, first stream is ours
+			host.conns = append(host.conns, conn)
+		}
+		host.dialing = nil
+		close(dialing)
+		host.mx.Unlock()
+		return conn, times, err
+	}
+}
+
+// reserve takes a stream slot on one of alive connections, connections that got GOAWAY are drained.
+// Has to be called with host lock held.
+func (host *h2HostConns) reserve(hostname string) (*H2Conn, error) {
+	alive := host.conns[:0]
+	for _, conn := range host.conns {
+		if conn.Alive() {
+			alive = append(alive, conn)
+		} else {
+			log.Debugf("Draining HTTP/2 connection to %s", hostname)
+			go conn.drain()
+		}
+	}
+	host.conns = alive
+
+	for _, conn := range host.conns {
+		ok, err := conn.reserve()
+		if err != nil { // opening more connections won't help when server refuses streams
+			return nil, err
+		}
+		if ok {
+			return conn, nil
+		}
+	}
+	return nil, nil
+}
+
+func (p *H2Pool) dial(hostname string, hostHint string) (*H2Conn, ConnTimes, error) {
+	c, times, err := p.ConnPool.openConnection(hostname, hostHint)
+	if err != nil {
+		return nil, times, err
+	}
+
+	conn, err := newH2Conn(c)
+	return conn, times, err
+}
+
+func (p *H2Pool) Release(conn *H2Conn) {
+	conn.release()
+}
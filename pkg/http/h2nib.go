@@ -0,0 +1,169 @@
+package http
+
+import (
+	"bytes"
+	"encarno/pkg/core"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/http2/hpack"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	core.RegisterNib("http2", core.NibDriver{
+		Description: "HTTP/2 over TLS with ALPN or with prior knowledge (h2c)",
+		Options:     driverOptions,
+		Factory: func(conf core.ProtoConf, options interface{}) (core.NibMaker, error) {
+			opts := options.(*Options)
+			pool := NewH2Pool(NewPoolFromConf(conf))
+
+			return func() core.Nib {
+				return &H2Nib{
+					Pool:       pool,
+					DecodeBody: opts.DecodeBody,
+				}
+			}, nil
+		},
+	})
+}
+
+// H2Nib sends the same raw HTTP/1.1 payloads as Nib, translating them into HTTP/2 streams
+type H2Nib struct {
+	Pool       *H2Pool
+	DecodeBody bool // extractors and asserts see decoded body
+}
+
+func (n *H2Nib) Punch(item *core.PayloadItem) *core.OutputItem {
+	outItem := core.NewOutputItem()
+	outItem.StartTime = time.Now()
+
+	hostHint, _, bodyLen := getHostAndConnHeaderValues(item.Payload)
+	if len(item.Replaces) > 0 {
+		item.Payload = contentLengthRe.ReplaceAll(item.Payload, []byte(strconv.Itoa(bodyLen)))
+	}
+
+	req, err := payloadToH2Request(item.Address, item.Payload)
+	if err != nil {
+		outItem.EndWithError(err)
+		return outItem
+	}
+
+	if len(item.RegexOut) > 0 || len(item.Asserts) > 0 {
+		req.RecordLimit = 0
+	} else {
+		req.RecordLimit = 1024 * 1024
+	}
+
+	before := time.Now()
+	conn, times, err := n.Pool.Get(item.Address, hostHint)
+	connected := time.Now()
+	outItem.ConnectTime = connected.Sub(before)
+	times.Fill(outItem)
+	if err != nil {
+		outItem.EndWithError(err)
+		return outItem
+	}
+	defer n.Pool.Release(conn)
+
+	log.Debugf("Sending HTTP/2 request with %d bytes body", len(req.Body))
+	resp, err := conn.RoundTrip(req, n.Pool.ConnPool.Timeout)
+	finish := time.Now()
+
+	outItem.SentBytesCount = uint64(resp.SentBytes)
+	if !resp.SentTime.IsZero() {
+		outItem.SentTime = resp.SentTime.Sub(connected)
+		if !resp.FirstRead.IsZero() {
+			outItem.FirstByteTime = resp.FirstRead.Sub(resp.SentTime)
+			outItem.ReadTime = finish.Sub(resp.FirstRead)
+		}
+	}
+	outItem.RespBytesCount = uint64(resp.ReadLen)
+	outItem.Elapsed = finish.Sub(outItem.StartTime)
+
+	if err != nil {
+		outItem.EndWithError(err)
+		return outItem
+	}
+
+	outItem.Status = uint16(resp.Status)
+	outItem.RespBytes = resp.Bytes()
+
+	if n.DecodeBody && (len(item.RegexOut) > 0 || len(item.Asserts) > 0) {
+		body, err := decodeBody(resp.Header("content-encoding"), resp.Body.Bytes())
+		if err != nil {
+			outItem.Error = err
+		} else {
+			outItem.MatchBytes = resp.render(body)
+		}
+	}
+	return outItem
+}
+
+// payloadToH2Request parses raw HTTP/1.1 request into the list of HTTP/2 header fields and body
+func payloadToH2Request(address string, payload []byte) (*H2Request, error) {
+	reqLine, rest, found := bytes.Cut(payload, []byte{10})
+	if !found {
+		return nil, errors.New("Failed to find request line in payload")
+	}
+
+	method, reqLine, _ := bytes.Cut(bytes.TrimSpace(reqLine), []byte{' '})
+	path, _, _ := bytes.Cut(reqLine, []byte{' '})
+	if len(method) == 0 || len(path) == 0 {
+		return nil, errors.New(fmt.Sprintf("Malformed request line in payload: %s", reqLine))
+	}
+
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	parsed, err := url.Parse(address)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to parse address '%s' as URL: %s", address, err))
+	}
+
+	scheme := "http"
+	if s, _ := UnixScheme(parsed.Scheme); s == "https" {
+		scheme = "https"
+	}
+
+	authority := parsed.Host
+	if authority == "" {
+		authority = "localhost" // for unix sockets, when there is no Host header
+	}
+	headers := make([]hpack.HeaderField, 0)
+	for {
+		line, after, found := bytes.Cut(rest, []byte{10})
+		line = bytes.TrimRight(line, "\r")
+		rest = after
+		if !found || len(line) < 2 { // minimal possible header is "x:"
+			break
+		}
+
+		name, value, _ := bytes.Cut(line, []byte{':'})
+		hname := strings.ToLower(strings.TrimSpace(string(name)))
+		hval := strings.TrimSpace(string(value))
+		switch hname {
+		case "host":
+			authority = hval
+		case "connection", "keep-alive", "proxy-connection", "transfer-encoding", "upgrade":
+			// connection-specific headers are forbidden in HTTP/2
+		default:
+			headers = append(headers, hpack.HeaderField{Name: hname, Value: hval})
+		}
+	}
+
+	pseudo := []hpack.HeaderField{
+		{Name: ":method", Value: string(method)},
+		{Name: ":scheme", Value: scheme},
+		{Name: ":authority", Value: authority},
+		{Name: ":path", Value: string(path)},
+	}
+
+	return &H2Request{
+		Headers: append(pseudo, headers...),
+		Body:    rest,
+	}, nil
+}
@@ -0,0 +1,287 @@
+package http
+
+import (
+	"bytes"
+	"encarno/pkg/core"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"golang.org/x/net/http2/hpack"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func h2Handler(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	w.Header().Set("X-Proto", r.Proto)
+	w.Header().Set("X-Method", r.Method)
+	_, _ = w.Write([]byte(r.Host + " " + r.URL.String() + " " + string(body)))
+}
+
+func TestH2NibTLS(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(h2Handler))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	nib := H2Nib{
+		Pool: NewH2Pool(NewConnectionPool(1, 1*time.Second, core.TLSConf{InsecureSkipVerify: true})),
+	}
+
+	item := core.PayloadItem{
+		Address: srv.URL,
+		Payload: []byte("POST /path?q=1 HTTP/1.1\r\nHost: example.com\r\nConnection: keep-alive\r\n\r\nbody"),
+		Asserts: []*core.AssertItem{{Re: &core.RegexpProxy{Regexp: regexp.MustCompile("X-Proto: HTTP/2.0")}}},
+	}
+
+	res := nib.Punch(&item)
+	if res.Error != nil {
+		t.Fatalf("Should not fail: %s", res.Error)
+	}
+
+	if res.Status != 200 {
+		t.Errorf("Wrong status: %d", res.Status)
+	}
+
+	res.Assert(item.Asserts)
+	if res.Error != nil {
+		t.Errorf("Assert failed: %s\n%s", res.Error, res.RespBytes)
+	}
+
+	if !strings.HasSuffix(string(res.RespBytes), "example.com /path?q=1 body") {
+		t.Errorf("Wrong response: %s", res.RespBytes)
+	}
+
+	if res.SentBytesCount == 0 || res.RespBytesCount == 0 || res.Elapsed == 0 {
+		t.Errorf("Counters not filled: %v", res)
+	}
+}
+
+func TestH2NibPriorKnowledge(t *testing.T) {
+	srv := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(h2Handler), &http2.Server{}))
+	defer srv.Close()
+
+	nib := H2Nib{
+		Pool: NewH2Pool(NewConnectionPool(1, 1*time.Second, core.TLSConf{})),
+	}
+
+	address := strings.TrimPrefix(srv.URL, "http://")
+	wg := sync.WaitGroup{}
+	for x := 0; x < 20; x++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			item := core.PayloadItem{
+				Address: address,
+				Payload: []byte("GET / HTTP/1.1\r\n\r\n"),
+			}
+			res := nib.Punch(&item)
+			if res.Error != nil || res.Status != 200 {
+				t.Errorf("Unexpected result: %d %v", res.Status, res.Error)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(nib.Pool.hosts[address].conns) != 1 {
+		t.Errorf("Streams should be multiplexed over single connection, got %d", len(nib.Pool.hosts[address].conns))
+	}
+}
+
+func TestH2NibLargeBody(t *testing.T) {
+	srv := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(h2Handler), &http2.Server{}))
+	defer srv.Close()
+
+	nib := H2Nib{
+		Pool: NewH2Pool(NewConnectionPool(1, 5*time.Second, core.TLSConf{})),
+	}
+
+	body := strings.Repeat("x", 1024*1024)
+	item := core.PayloadItem{
+		Address: srv.URL,
+		Payload: []byte("PUT / HTTP/1.1\r\n\r\n" + body),
+		Asserts: []*core.AssertItem{{Re: &core.RegexpProxy{Regexp: regexp.MustCompile("x+$")}}},
+	}
+	res := nib.Punch(&item)
+	if res.Error != nil {
+		t.Fatalf("Should not fail: %s", res.Error)
+	}
+
+	if !strings.HasSuffix(string(res.RespBytes), body) {
+		t.Errorf("Body was not echoed, got %d bytes", len(res.RespBytes))
+	}
+}
+
+// rawH2Server accepts connections on listener and hands them to serve after reading client preface
+func rawH2Server(t *testing.T, serve func(framer *http2.Framer)) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			go func(c net.Conn) {
+				defer c.Close()
+				preface := make([]byte, len(http2.ClientPreface))
+				if _, err := io.ReadFull(c, preface); err != nil {
+					return
+				}
+				serve(http2.NewFramer(c, c))
+			}(c)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// goAwayServer answers first stream only after telling client with GOAWAY that no more streams are accepted
+func goAwayServer(sent chan struct{}) func(framer *http2.Framer) {
+	return func(framer *http2.Framer) {
+		_ = framer.WriteSettings()
+		for {
+			frame, err := framer.ReadFrame()
+			if err != nil {
+				return
+			}
+
+			f, ok := frame.(*http2.HeadersFrame)
+			if !ok {
+				continue
+			}
+
+			_ = framer.WriteGoAway(f.StreamID, http2.ErrCodeNo, nil)
+			close(sent)
+			time.Sleep(200 * time.Millisecond)
+
+			buf := bytes.Buffer{}
+			enc := hpack.NewEncoder(&buf)
+			_ = enc.WriteField(hpack.HeaderField{Name: ":status", Value: "200"})
+			_ = framer.WriteHeaders(http2.HeadersFrameParam{StreamID: f.StreamID, BlockFragment: buf.Bytes(), EndHeaders: true})
+			_ = framer.WriteData(f.StreamID, true, []byte("done"))
+		}
+	}
+}
+
+func TestH2NibGoAwayInFlight(t *testing.T) {
+	sent := make(chan struct{})
+	address := rawH2Server(t, goAwayServer(sent))
+
+	nib := H2Nib{
+		Pool: NewH2Pool(NewConnectionPool(1, 5*time.Second, core.TLSConf{})),
+	}
+
+	done := make(chan *core.OutputItem)
+	go func() {
+		done <- nib.Punch(&core.PayloadItem{Address: address, Payload: []byte("GET / HTTP/1.1\r\n\r\n")})
+	}()
+
+	<-sent
+	time.Sleep(50 * time.Millisecond)
+	first := nib.Pool.hosts[address].conns[0]
+	conn, _, err := nib.Pool.Get(address, "")
+	if err != nil {
+		t.Fatalf("Should open new connection: %s", err)
+	}
+	nib.Pool.Release(conn)
+
+	if conn == first {
+		t.Errorf("Connection after GOAWAY should not be reused")
+	}
+
+	res := <-done
+	if res.Error != nil || res.Status != 200 {
+		t.Fatalf("Stream in flight should complete after GOAWAY: %d %v", res.Status, res.Error)
+	}
+
+	first.mx.Lock()
+	defer first.mx.Unlock()
+	if first.Err != io.ErrClosedPipe {
+		t.Errorf("Drained connection should be closed after its last stream, got: %v", first.Err)
+	}
+}
+
+func TestH2NibWindowTimeout(t *testing.T) {
+	address := rawH2Server(t, func(framer *http2.Framer) {
+		_ = framer.WriteSettings()
+		for { // never giving more window
+			if _, err := framer.ReadFrame(); err != nil {
+				return
+			}
+		}
+	})
+
+	nib := H2Nib{
+		Pool: NewH2Pool(NewConnectionPool(1, 200*time.Millisecond, core.TLSConf{})),
+	}
+
+	done := make(chan *core.OutputItem)
+	go func() {
+		done <- nib.Punch(&core.PayloadItem{Address: address, Payload: []byte("PUT / HTTP/1.1\r\n\r\n" + strings.Repeat("x", 200000))})
+	}()
+
+	select {
+	case res := <-done:
+		if res.Error == nil || !strings.Contains(res.Error.Error(), "timed out") {
+			t.Errorf("Should time out waiting for window, got: %v", res.Error)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Request should not block past its timeout")
+	}
+}
+
+func TestH2PoolNoStreamsAllowed(t *testing.T) {
+	var dials int32
+	address := rawH2Server(t, func(framer *http2.Framer) {
+		atomic.AddInt32(&dials, 1)
+		_ = framer.WriteSettings(http2.Setting{ID: http2.SettingMaxConcurrentStreams, Val: 0})
+		for {
+			if _, err := framer.ReadFrame(); err != nil {
+				return
+			}
+		}
+	})
+
+	pool := NewH2Pool(NewConnectionPool(1, 1*time.Second, core.TLSConf{}))
+	first, _, err := pool.Get(address, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.Release(first)
+	time.Sleep(100 * time.Millisecond) // let settings arrive
+
+	for i := 0; i < 3; i++ {
+		if _, _, err := pool.Get(address, ""); err != errNoStreams {
+			t.Errorf("Should refuse streams, got: %v", err)
+		}
+	}
+
+	if n := atomic.LoadInt32(&dials); n != 1 {
+		t.Errorf("Should not open connection per request, opened %d", n)
+	}
+}
+
+func TestNewH2PoolNextProtos(t *testing.T) {
+	pool := NewH2Pool(NewConnectionPool(1, 1*time.Second, core.TLSConf{NextProtos: []string{"http/1.1"}}))
+	if !reflect.DeepEqual(pool.ConnPool.NextProtos, []string{"h2", "http/1.1"}) {
+		t.Errorf("Should offer h2 in addition to configured protocols: %v", pool.ConnPool.NextProtos)
+	}
+
+	pool = NewH2Pool(NewConnectionPool(1, 1*time.Second, core.TLSConf{NextProtos: []string{"custom", "h2"}}))
+	if !reflect.DeepEqual(pool.ConnPool.NextProtos, []string{"custom", "h2"}) {
+		t.Errorf("Should keep configured protocols: %v", pool.ConnPool.NextProtos)
+	}
+}
@@ -0,0 +1,170 @@
+package http
+
+import (
+	log "github.com/sirupsen/logrus"
+	"io"
+	"sync"
+	"time"
+)
+
+// hostPool counts open connections to single host and keeps the idle ones,
+// callers that hit the limit wait in queue for either idle connection or free slot
+type hostPool struct {
+	open    int
+	idle    []*BufferedConn
+	waiters []chan *BufferedConn // nil sent into channel means free slot to open new connection
+	mx      *sync.Mutex
+}
+
+// acquire returns idle connection to reuse, or nil when caller is allowed to open new one
+func (h *hostPool) acquire(p *ConnPool, now time.Time) (*BufferedConn, error) {
+	h.mx.Lock()
+	stale := make([]*BufferedConn, 0)
+	defer func() {
+		for _, conn := range stale {
+			go conn.Close()
+		}
+	}()
+
+	stale = append(stale, h.expire(p, now)...)
+	for len(h.idle) > 0 {
+		conn := h.idle[len(h.idle)-1]
+		h.idle = h.idle[:len(h.idle)-1]
+
+		if h.usable(p, conn, now) {
+			h.mx.Unlock()
+			return conn, nil
+		}
+
+		if !conn.released {
+			conn.released = true
+			h.open--
+		}
+		stale = append(stale, conn)
+	}
+
+	if p.MaxConnections <= 0 || h.open < p.MaxConnections {
+		h.open++
+		h.mx.Unlock()
+		return nil, nil
+	}
+
+	log.Debugf("All %d connections are busy, waiting for free one", h.open)
+	ch := make(chan *BufferedConn, 1)
+	h.waiters = append(h.waiters, ch)
+	h.mx.Unlock()
+
+	timer := time.NewTimer(p.WaitTimeout)
+	defer timer.Stop()
+	select {
+	case conn := <-ch:
+		return conn, nil
+	case <-timer.C:
+	}
+
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	for i, w := range h.waiters {
+		if w == ch {
+			h.waiters = append(h.waiters[:i], h.waiters[i+1:]...)
+			return nil, ErrWaitTimeout
+		}
+	}
+
+	// got handed over right at timeout, still taking it
+	return <-ch, nil
+}
+
+func (h *hostPool) usable(p *ConnPool, conn *BufferedConn, now time.Time) bool {
+	if err := conn.GetErr(); err == io.EOF {
+		log.Debugf("Cannot reuse Idle connection: %v", err)
+		return false
+	} else if err != nil {
+		log.Warningf("Cannot reuse Idle connection: %v", err)
+		return false
+	}
+
+	if conn.Canceled || conn.released {
+		return false
+	}
+
+	if p.MaxIdleTime > 0 && now.Sub(conn.idleSince) >= p.MaxIdleTime {
+		log.Debugf("Idle connection has expired")
+		return false
+	}
+
+	if !conn.alive() {
+		log.Debugf("Idle connection was closed by server")
+		return false
+	}
+
+	return p.reusable(conn, now)
+}
+
+// expire takes connections idle for longer than MaxIdleTime out of the pool, they are at the bottom of idle stack
+func (h *hostPool) expire(p *ConnPool, now time.Time) []*BufferedConn {
+	if p.MaxIdleTime <= 0 {
+		return nil
+	}
+
+	cnt := 0
+	for cnt < len(h.idle) && now.Sub(h.idle[cnt].idleSince) >= p.MaxIdleTime {
+		if !h.idle[cnt].released {
+			h.idle[cnt].released = true
+			h.open--
+		}
+		cnt++
+	}
+
+	if cnt > 0 {
+		log.Debugf("Closing %d expired idle connections", cnt)
+	}
+
+	expired := make([]*BufferedConn, cnt)
+	copy(expired, h.idle[:cnt])
+	h.idle = append(h.idle[:0], h.idle[cnt:]...)
+	return expired
+}
+
+// put gives connection to the first waiter, or keeps it idle
+func (h *hostPool) put(p *ConnPool, conn *BufferedConn) {
+	h.mx.Lock()
+	now := time.Now()
+	expired := h.expire(p, now)
+	defer func() {
+		for _, conn := range expired {
+			go conn.Close()
+		}
+	}()
+	defer h.mx.Unlock()
+
+	if len(h.waiters) > 0 {
+		h.waiters[0] <- conn
+		h.waiters = h.waiters[1:]
+		return
+	}
+
+	conn.idleSince = now
+	h.idle = append(h.idle, conn)
+}
+
+// release frees the slot of closed connection, or the one taken for connection that failed to open
+func (h *hostPool) release(conn *BufferedConn) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	if conn != nil {
+		if conn.released {
+			return
+		}
+		conn.released = true
+	}
+
+	if len(h.waiters) > 0 {
+		h.waiters[0] <- nil
+		h.waiters = h.waiters[1:]
+		return
+	}
+
+	h.open--
+}
@@ -12,22 +12,41 @@ import (
 	"time"
 )
 
+func init() {
+	core.RegisterNib("http", core.NibDriver{
+		Description: "HTTP/1.1 over plain or TLS connections",
+		Options:     driverOptions,
+		Factory: func(conf core.ProtoConf, options interface{}) (core.NibMaker, error) {
+			opts := options.(*Options)
+			pool := NewPoolFromConf(conf)
+			pool.ForwardPlain = true
+
+			return func() core.Nib {
+				return &Nib{
+					ConnPool:   pool,
+					DecodeBody: opts.DecodeBody,
+				}
+			}, nil
+		},
+	})
+}
+
 type Nib struct {
-	ConnPool *ConnPool
+	ConnPool   *ConnPool
+	DecodeBody bool // extractors and asserts see decoded body
 }
 
 func (n *Nib) Punch(item *core.PayloadItem) *core.OutputItem {
-	outItem := core.OutputItem{
-		StartTime: time.Now(),
-	}
+	outItem := core.NewOutputItem()
+	outItem.StartTime = time.Now()
 
-	conn, connClose := n.sendRequest(item, &outItem)
+	conn, connClose := n.sendRequest(item, outItem)
 	if outItem.Error != nil {
-		return &outItem
+		return outItem
 	}
 
-	n.readResponse(item, conn, &outItem, connClose)
-	return &outItem
+	n.readResponse(item, conn, outItem, connClose)
+	return outItem
 }
 
 var contentLengthRe = regexp.MustCompile(`(?m:\$\{:content-length:})`)
@@ -47,6 +66,7 @@ func (n *Nib) sendRequest(item *core.PayloadItem, outItem *core.OutputItem) (*Bu
 		outItem.EndWithError(err)
 		return nil, connClose
 	}
+	conn.Times.Fill(outItem)
 
 	if len(item.RegexOut) > 0 || len(item.Asserts) > 0 {
 		conn.ReadRecordLimit = 0
@@ -56,15 +76,18 @@ func (n *Nib) sendRequest(item *core.PayloadItem, outItem *core.OutputItem) (*Bu
 
 	if err := conn.SetDeadline(time.Now().Add(n.ConnPool.Timeout)); err != nil {
 		outItem.EndWithError(err)
+		go conn.Close()
 		return nil, connClose
 	}
 
-	log.Debugf("Writing %d bytes into connection", len(item.Payload))
-	write, err := conn.Write(item.Payload)
+	payload := n.ConnPool.ProxyPayload(item.Address, item.Payload)
+	log.Debugf("Writing %d bytes into connection", len(payload))
+	write, err := conn.WritePayload(item, payload)
 	outItem.SentBytesCount = uint64(write)
 	outItem.SentTime = time.Now().Sub(connected)
 	if err != nil {
 		outItem.EndWithError(err)
+		go conn.Close()
 		return nil, connClose
 	}
 	return conn, connClose
@@ -103,6 +126,7 @@ func (n *Nib) readResponse(item *core.PayloadItem, conn *BufferedConn, result *c
 	result.ReadTime = time.Now().Sub(begin) // in case there will be an error
 	if err != nil {
 		result.EndWithError(err)
+		go conn.Close()
 		return
 	}
 	result.Status = uint16(resp.StatusCode)
@@ -111,8 +135,18 @@ func (n *Nib) readResponse(item *core.PayloadItem, conn *BufferedConn, result *c
 		result.FirstByteTime = conn.FirstRead.Sub(begin)
 	}
 
-	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
-		result.EndWithError(err) // TODO: unclosed connection leak?
+	var body *bytes.Buffer
+	if n.DecodeBody && (len(item.RegexOut) > 0 || len(item.Asserts) > 0) {
+		body = core.GetBuffer()
+		defer core.PutBuffer(body)
+		_, err = io.Copy(body, resp.Body) // de-chunked by reader
+	} else {
+		_, err = io.Copy(io.Discard, resp.Body)
+	}
+
+	if err != nil {
+		result.EndWithError(err)
+		go conn.Close()
 		return
 	}
 
@@ -126,7 +160,15 @@ func (n *Nib) readResponse(item *core.PayloadItem, conn *BufferedConn, result *c
 	}
 
 	result.RespBytesCount = uint64(conn.ReadLen)
-	result.RespBytes = conn.ReadRecorded.Bytes()
+	result.SetRespBuffer(conn.TakeRecorded())
+
+	if body != nil {
+		view, err := decodedView(result.RespBytes, resp.Header.Get("Content-Encoding"), body.Bytes())
+		result.SetMatchBuffer(view)
+		if err != nil {
+			result.Error = err
+		}
+	}
 
 	// close or reuse
 	if resp.Close || connClose {
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package http
+
+import (
+	"syscall"
+)
+
+// peekAlive cannot check the socket without reading from it, so the dead connection is discovered on use
+func peekAlive(raw syscall.RawConn) bool {
+	return true
+}
@@ -0,0 +1,19 @@
+//go:build unix
+
+package http
+
+import (
+	"syscall"
+)
+
+// peekAlive looks into socket without blocking and consuming anything, idle connection must have nothing to read
+func peekAlive(raw syscall.RawConn) bool {
+	alive := false
+	err := raw.Read(func(fd uintptr) bool {
+		buf := make([]byte, 1)
+		n, _, err := syscall.Recvfrom(int(fd), buf, syscall.MSG_PEEK|syscall.MSG_DONTWAIT)
+		alive = n < 0 && (err == syscall.EAGAIN || err == syscall.EWOULDBLOCK)
+		return true // never wait for readiness
+	})
+	return err == nil && alive
+}
@@ -0,0 +1,278 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+func parseProxy(proxy string) *url.URL {
+	if proxy == "" {
+		return nil
+	}
+
+	parsed, err := url.Parse(proxy)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to parse proxy URL '%s': %s", proxy, err))
+	}
+
+	switch parsed.Scheme {
+	case "http", "socks5", "socks5h":
+	default:
+		panic(fmt.Sprintf("Unsupported proxy scheme: %s", parsed.Scheme))
+	}
+	return parsed
+}
+
+// dialProxy opens connection to proxy and makes it tunnel to target, unless plain HTTP is forwarded
+func (p *ConnPool) dialProxy(ctx context.Context, target string, secure bool, times *ConnTimes) (net.Conn, error) {
+	host, port := SplitHostPort(p.Proxy.Host)
+	if port == "" && p.Proxy.Scheme == "http" {
+		port = "80"
+	} else if port == "" {
+		port = "1080"
+	}
+
+	conn, err := p.dialTCP(ctx, host, port, times)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.Proxy.Scheme == "socks5" { // unlike socks5h, target name is resolved locally
+		target, err = p.resolveTarget(ctx, target, times)
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+
+	if p.Proxy.Scheme == "http" && p.ForwardPlain && !secure {
+		return conn, nil
+	}
+
+	start := time.Now()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	log.Debugf("Opening tunnel to %s via %s proxy %s", target, p.Proxy.Scheme, p.Proxy.Host)
+	if p.Proxy.Scheme == "http" {
+		err = p.httpConnect(conn, target)
+	} else {
+		err = p.socks5Connect(conn, target)
+	}
+	times.Dial += time.Now().Sub(start)
+
+	if err != nil {
+		_ = conn.Close()
+		return nil, errors.New(fmt.Sprintf("Proxy failed to connect to %s: %s", target, err))
+	}
+
+	_ = conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// resolveTarget replaces target host name with its address picked by resolver
+func (p *ConnPool) resolveTarget(ctx context.Context, target string, times *ConnTimes) (string, error) {
+	host, port := SplitHostPort(target)
+	start := time.Now()
+	ips, err := p.Resolver.Resolve(ctx, host)
+	times.DNS += time.Now().Sub(start)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(ips[0].String(), port), nil
+}
+
+func (p *ConnPool) proxyAuthHeader() string {
+	if p.Proxy.User == nil {
+		return ""
+	}
+	pass, _ := p.Proxy.User.Password()
+	creds := base64.StdEncoding.EncodeToString([]byte(p.Proxy.User.Username() + ":" + pass))
+	return "Proxy-Authorization: Basic " + creds + "\r\n"
+}
+
+func (p *ConnPool) httpConnect(conn net.Conn, target string) error {
+	req := "CONNECT " + target + " HTTP/1.1\r\nHost: " + target + "\r\n" + p.proxyAuthHeader() + "\r\n"
+	if _, err := conn.Write([]byte(req)); err != nil {
+		return err
+	}
+
+	// reading byte by byte, to not consume anything after the response
+	resp, err := http.ReadResponse(bufio.NewReaderSize(byteReader{conn}, 16), nil)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(resp.Status)
+	}
+	return nil
+}
+
+type byteReader struct {
+	r io.Reader
+}
+
+func (b byteReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	return b.r.Read(p[:1])
+}
+
+var socks5Errors = []string{
+	"succeeded",
+	"general SOCKS server failure",
+	"connection not allowed by ruleset",
+	"network unreachable",
+	"host unreachable",
+	"connection refused",
+	"TTL expired",
+	"command not supported",
+	"address type not supported",
+}
+
+// socks5Connect does RFC 1928 CONNECT with optional RFC 1929 username/password auth, host names are resolved by proxy
+func (p *ConnPool) socks5Connect(conn net.Conn, target string) error {
+	host, portStr, err := net.SplitHostPort(target)
+	if err != nil {
+		return err
+	}
+
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return err
+	}
+
+	method := byte(0x00)
+	if p.Proxy.User != nil {
+		method = 0x02
+	}
+
+	if _, err := conn.Write([]byte{0x05, 0x01, method}); err != nil {
+		return err
+	}
+
+	resp := make([]byte, 2)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return err
+	}
+
+	if resp[0] != 0x05 || resp[1] != method {
+		return errors.New(fmt.Sprintf("SOCKS5 proxy does not accept auth method %d", method))
+	}
+
+	if method == 0x02 {
+		user := p.Proxy.User.Username()
+		pass, _ := p.Proxy.User.Password()
+		if len(user) > 255 || len(pass) > 255 {
+			return errors.New("SOCKS5 username and password have to be shorter than 256 bytes")
+		}
+
+		auth := append([]byte{0x01, byte(len(user))}, user...)
+		auth = append(append(auth, byte(len(pass))), pass...)
+		if _, err := conn.Write(auth); err != nil {
+			return err
+		}
+
+		if _, err := io.ReadFull(conn, resp); err != nil {
+			return err
+		}
+
+		if resp[1] != 0x00 {
+			return errors.New("SOCKS5 authentication failed")
+		}
+	}
+
+	req := []byte{0x05, 0x01, 0x00}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return errors.New("SOCKS5 host name is too long")
+		}
+		req = append(append(req, 0x03, byte(len(host))), host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(append(req, 0x01), ip4...)
+	} else {
+		req = append(append(req, 0x04), ip...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return err
+	}
+
+	if head[1] != 0x00 {
+		if int(head[1]) < len(socks5Errors) {
+			return errors.New("SOCKS5 " + socks5Errors[head[1]])
+		}
+		return errors.New(fmt.Sprintf("SOCKS5 error code %d", head[1]))
+	}
+
+	// skipping bound address we don't need
+	skip := 0
+	switch head[3] {
+	case 0x01:
+		skip = net.IPv4len
+	case 0x04:
+		skip = net.IPv6len
+	case 0x03:
+		if _, err := io.ReadFull(conn, head[:1]); err != nil {
+			return err
+		}
+		skip = int(head[0])
+	default:
+		return errors.New(fmt.Sprintf("SOCKS5 unknown address type %d", head[3]))
+	}
+
+	_, err = io.ReadFull(conn, make([]byte, skip+2))
+	return err
+}
+
+// ProxyPayload turns HTTP request into absolute-URI form when it is forwarded via plain HTTP proxy
+func (p *ConnPool) ProxyPayload(address string, payload []byte) []byte {
+	if p.Proxy == nil || p.Proxy.Scheme != "http" || !p.ForwardPlain {
+		return payload
+	}
+
+	scheme, host, found := bytes.Cut([]byte(address), []byte("://"))
+	if !found {
+		scheme, host = []byte("http"), []byte(address)
+	} else if string(scheme) != "http" {
+		return payload
+	}
+
+	method, rest, _ := bytes.Cut(payload, []byte{' '})
+	if !bytes.HasPrefix(rest, []byte{'/'}) {
+		return payload // already absolute, or not HTTP at all
+	}
+
+	res := make([]byte, 0, len(payload)+len(host)+64)
+	res = append(append(res, method...), ' ')
+	res = append(append(append(res, scheme...), "://"...), host...)
+
+	if auth := p.proxyAuthHeader(); auth != "" {
+		reqLine, headers, _ := bytes.Cut(rest, []byte{'\n'})
+		res = append(append(res, reqLine...), '\n')
+		res = append(res, auth...)
+		return append(res, headers...)
+	}
+	return append(res, rest...)
+}
@@ -0,0 +1,219 @@
+package http
+
+import (
+	"bufio"
+	"encarno/pkg/core"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func proxyListener(t *testing.T, handle func(conn net.Conn)) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go handle(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func tunnel(conn net.Conn, target string) {
+	upstream, err := net.Dial("tcp", target)
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	go func() {
+		_, _ = io.Copy(upstream, conn)
+		_ = upstream.Close()
+	}()
+	_, _ = io.Copy(conn, upstream)
+	_ = conn.Close()
+}
+
+func TestProxyForward(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("forwarded " + r.RequestURI + " " + r.Header.Get("Proxy-Authorization")))
+	}))
+	defer srv.Close()
+
+	pool := NewPoolFromConf(core.ProtoConf{Timeout: time.Second, Proxy: "http://user:pass@" + srv.Listener.Addr().String()})
+	pool.ForwardPlain = true
+	nib := Nib{ConnPool: pool}
+	item := core.PayloadItem{
+		Address: "http://target.invalid:8080",
+		Payload: []byte("GET /path?q=1 HTTP/1.1\r\nHost: target.invalid\r\n\r\n"),
+	}
+
+	res := nib.Punch(&item)
+	if res.Error != nil {
+		t.Fatalf("Should not fail: %s", res.Error)
+	}
+	if !strings.HasSuffix(string(res.RespBytes), "forwarded http://target.invalid:8080/path?q=1 Basic dXNlcjpwYXNz") {
+		t.Errorf("Wrong response: %s", res.RespBytes)
+	}
+}
+
+func TestProxyConnect(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("tunneled"))
+	}))
+	defer srv.Close()
+
+	proxy := proxyListener(t, func(conn net.Conn) {
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil || req.Method != "CONNECT" {
+			_ = conn.Close()
+			return
+		}
+
+		if req.Host != "localhost:"+strconv.Itoa(srv.Listener.Addr().(*net.TCPAddr).Port) {
+			_, _ = conn.Write([]byte("HTTP/1.1 403 Forbidden\r\n\r\n"))
+			_ = conn.Close()
+			return
+		}
+
+		_, _ = conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		tunnel(conn, srv.Listener.Addr().String())
+	})
+
+	pool := NewPoolFromConf(core.ProtoConf{
+		Timeout: time.Second,
+		Proxy:   "http://" + proxy,
+		TLSConf: core.TLSConf{InsecureSkipVerify: true},
+	})
+	pool.ForwardPlain = true
+	nib := Nib{ConnPool: pool}
+
+	item := core.PayloadItem{
+		Address: strings.Replace(srv.URL, "127.0.0.1", "localhost", 1),
+		Payload: []byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"),
+	}
+	res := nib.Punch(&item)
+	if res.Error != nil || !strings.HasSuffix(string(res.RespBytes), "tunneled") {
+		t.Fatalf("Should tunnel: %v\n%s", res.Error, res.RespBytes)
+	}
+
+	item.Address = "https://forbidden.invalid"
+	res = nib.Punch(&item)
+	if res.Error == nil || res.Error.Error() != "Proxy failed to connect to forbidden.invalid:443: 403 Forbidden" {
+		t.Errorf("Should fail on proxy refusal, got: %v", res.Error)
+	}
+}
+
+func TestProxySOCKS5H(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("socks"))
+	}))
+	defer srv.Close()
+	_, port := SplitHostPort(srv.Listener.Addr().String())
+
+	proxy := proxyListener(t, func(conn net.Conn) {
+		buf := make([]byte, 512)
+		_, _ = io.ReadFull(conn, buf[:3]) // version, 1 method, username/password
+		_, _ = conn.Write([]byte{0x05, 0x02})
+
+		readField := func() string {
+			_, _ = io.ReadFull(conn, buf[:1])
+			field := make([]byte, buf[0])
+			_, _ = io.ReadFull(conn, field)
+			return string(field)
+		}
+		_, _ = io.ReadFull(conn, buf[:1]) // auth version
+		user := readField()
+		pass := readField()
+		if user != "user" || pass != "pass" {
+			_, _ = conn.Write([]byte{0x01, 0x01})
+			_ = conn.Close()
+			return
+		}
+		_, _ = conn.Write([]byte{0x01, 0x00})
+
+		_, _ = io.ReadFull(conn, buf[:5]) // version, connect, reserved, domain name type, name length
+		name := make([]byte, buf[4]+2)
+		_, _ = io.ReadFull(conn, name)
+		host := string(name[:len(name)-2])
+		target := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(name[len(name)-2:]))))
+
+		if host != "localhost" {
+			_, _ = conn.Write([]byte{0x05, 0x04, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+			_ = conn.Close()
+			return
+		}
+
+		_, _ = conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 127, 0, 0, 1, 0, 0})
+		tunnel(conn, strings.Replace(target, "localhost", "127.0.0.1", 1))
+	})
+
+	nib := Nib{ConnPool: NewPoolFromConf(core.ProtoConf{Timeout: time.Second, Proxy: "socks5h://user:pass@" + proxy})}
+	item := core.PayloadItem{
+		Address: "localhost:" + port,
+		Payload: []byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"),
+	}
+	res := nib.Punch(&item)
+	if res.Error != nil || !strings.HasSuffix(string(res.RespBytes), "socks") {
+		t.Fatalf("Should tunnel: %v\n%s", res.Error, res.RespBytes)
+	}
+
+	item.Address = "unreachable.invalid:" + port
+	res = nib.Punch(&item)
+	if res.Error == nil || !strings.HasSuffix(res.Error.Error(), "SOCKS5 host unreachable") {
+		t.Errorf("Should fail with SOCKS5 error, got: %v", res.Error)
+	}
+}
+
+func TestProxySOCKS5(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("socks"))
+	}))
+	defer srv.Close()
+	_, port := SplitHostPort(srv.Listener.Addr().String())
+
+	proxy := proxyListener(t, func(conn net.Conn) {
+		buf := make([]byte, 512)
+		_, _ = io.ReadFull(conn, buf[:3]) // version, 1 method, no auth
+		_, _ = conn.Write([]byte{0x05, 0x00})
+
+		_, _ = io.ReadFull(conn, buf[:4]) // version, connect, reserved, address type
+		if buf[3] != 0x01 {
+			_, _ = conn.Write([]byte{0x05, 0x08, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+			_ = conn.Close()
+			return
+		}
+
+		_, _ = io.ReadFull(conn, buf[:6])
+		target := net.JoinHostPort(net.IP(buf[:4]).String(), strconv.Itoa(int(binary.BigEndian.Uint16(buf[4:6]))))
+		_, _ = conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 127, 0, 0, 1, 0, 0})
+		tunnel(conn, target)
+	})
+
+	nib := Nib{ConnPool: NewPoolFromConf(core.ProtoConf{
+		Timeout: time.Second,
+		Proxy:   "socks5://" + proxy,
+		DNSConf: core.DNSConf{Hosts: map[string][]string{"target.test": {"127.0.0.1"}}},
+	})}
+	item := core.PayloadItem{
+		Address: "target.test:" + port,
+		Payload: []byte("GET / HTTP/1.1\r\nHost: target.test\r\n\r\n"),
+	}
+	res := nib.Punch(&item)
+	if res.Error != nil || !strings.HasSuffix(string(res.RespBytes), "socks") {
+		t.Fatalf("Should send locally resolved address to proxy: %v\n%s", res.Error, res.RespBytes)
+	}
+}
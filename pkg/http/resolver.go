@@ -0,0 +1,126 @@
+package http
+
+import (
+	"context"
+	"encarno/pkg/core"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	BalanceFirst      = ""
+	BalanceRoundRobin = "roundrobin"
+	BalanceRandom     = "random"
+)
+
+type resolved struct {
+	ips     []net.IP
+	expires time.Time
+	next    int
+}
+
+// Resolver looks up host addresses with optional caching and static overrides,
+// ordering them so that the first one is the address to try for the next connection
+type Resolver struct {
+	conf   core.DNSConf
+	static map[string][]net.IP
+	hosts  map[string]*resolved
+	mx     *sync.Mutex
+	lookup func(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+func NewResolver(conf core.DNSConf) *Resolver {
+	switch conf.Balance {
+	case BalanceFirst, BalanceRoundRobin, BalanceRandom:
+	default:
+		panic(fmt.Sprintf("Unsupported DNS balance mode: %s", conf.Balance))
+	}
+
+	static := map[string][]net.IP{}
+	for host, addrs := range conf.Hosts {
+		for _, addr := range addrs {
+			ip := net.ParseIP(addr)
+			if ip == nil {
+				panic(fmt.Sprintf("Static address for host %s is not an IP: %s", host, addr))
+			}
+			static[host] = append(static[host], ip)
+		}
+	}
+
+	return &Resolver{
+		conf:   conf,
+		static: static,
+		hosts:  map[string]*resolved{},
+		mx:     new(sync.Mutex),
+		lookup: net.DefaultResolver.LookupIPAddr,
+	}
+}
+
+func (r *Resolver) Resolve(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+
+	r.mx.Lock()
+	entry, ok := r.hosts[host]
+	if !ok {
+		entry = &resolved{}
+		r.hosts[host] = entry
+	}
+
+	if ips, ok := r.static[host]; ok {
+		entry.ips = ips
+	} else if entry.ips == nil || time.Now().After(entry.expires) {
+		r.mx.Unlock() // not holding lock while waiting for DNS server
+		ips, err := r.lookupIPs(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		r.mx.Lock()
+		entry.ips = ips
+		entry.expires = time.Now().Add(r.conf.CacheTTL)
+	}
+
+	ips := r.order(entry)
+	r.mx.Unlock()
+	return ips, nil
+}
+
+func (r *Resolver) lookupIPs(ctx context.Context, host string) ([]net.IP, error) {
+	log.Debugf("Resolving %s", host)
+	addrs, err := r.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(addrs) == 0 {
+		return nil, errors.New(fmt.Sprintf("No addresses found for host %s", host))
+	}
+
+	ips := make([]net.IP, len(addrs))
+	for i, addr := range addrs {
+		ips[i] = addr.IP
+	}
+	return ips, nil
+}
+
+// order rotates the list so that picked address goes first, the rest are kept as fallback
+func (r *Resolver) order(entry *resolved) []net.IP {
+	start := 0
+	switch r.conf.Balance {
+	case BalanceRoundRobin:
+		start = entry.next % len(entry.ips)
+		entry.next = start + 1
+	case BalanceRandom:
+		start = rand.Intn(len(entry.ips))
+	}
+
+	ips := make([]net.IP, 0, len(entry.ips))
+	ips = append(ips, entry.ips[start:]...)
+	return append(ips, entry.ips[:start]...)
+}
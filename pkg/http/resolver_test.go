@@ -0,0 +1,113 @@
+package http
+
+import (
+	"context"
+	"encarno/pkg/core"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func fakeLookup(calls *int, addrs ...string) func(ctx context.Context, host string) ([]net.IPAddr, error) {
+	return func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		*calls++
+		res := make([]net.IPAddr, 0)
+		for _, addr := range addrs {
+			res = append(res, net.IPAddr{IP: net.ParseIP(addr)})
+		}
+		return res, nil
+	}
+}
+
+func TestResolverRoundRobin(t *testing.T) {
+	calls := 0
+	r := NewResolver(core.DNSConf{Balance: BalanceRoundRobin, CacheTTL: time.Minute})
+	r.lookup = fakeLookup(&calls, "10.0.0.1", "10.0.0.2", "::3")
+
+	picked := make([]string, 0)
+	for x := 0; x < 4; x++ {
+		ips, err := r.Resolve(context.Background(), "balanced.test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ips) != 3 {
+			t.Errorf("All addresses should be returned as fallback: %v", ips)
+		}
+		picked = append(picked, ips[0].String())
+	}
+
+	if strings.Join(picked, ",") != "10.0.0.1,10.0.0.2,::3,10.0.0.1" {
+		t.Errorf("Wrong round-robin order: %v", picked)
+	}
+	if calls != 1 {
+		t.Errorf("Should be resolved once within TTL, got %d", calls)
+	}
+}
+
+func TestResolverNoCache(t *testing.T) {
+	calls := 0
+	r := NewResolver(core.DNSConf{})
+	r.lookup = fakeLookup(&calls, "10.0.0.1", "10.0.0.2")
+
+	for x := 0; x < 3; x++ {
+		ips, err := r.Resolve(context.Background(), "plain.test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ips[0].String() != "10.0.0.1" {
+			t.Errorf("Should always start with first address: %v", ips)
+		}
+	}
+	if calls != 3 {
+		t.Errorf("Should be resolved each time, got %d", calls)
+	}
+
+	ips, _ := r.Resolve(context.Background(), "::1")
+	if len(ips) != 1 || calls != 3 {
+		t.Errorf("IP literals should not be resolved: %v", ips)
+	}
+}
+
+func TestResolverStatic(t *testing.T) {
+	calls := 0
+	r := NewResolver(core.DNSConf{Hosts: map[string][]string{"canary.test": {"127.0.0.1"}}})
+	r.lookup = fakeLookup(&calls, "10.0.0.1")
+
+	ips, err := r.Resolve(context.Background(), "canary.test")
+	if err != nil || len(ips) != 1 || ips[0].String() != "127.0.0.1" || calls != 0 {
+		t.Errorf("Static address should be used: %v %v", ips, err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Should panic on bad static address")
+		}
+	}()
+	NewResolver(core.DNSConf{Hosts: map[string][]string{"canary.test": {"not-an-ip"}}})
+}
+
+func TestConnPoolPinnedHost(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("pinned"))
+	}))
+	defer srv.Close()
+
+	_, port := SplitHostPort(strings.TrimPrefix(srv.URL, "http://"))
+	nib := Nib{ConnPool: NewPoolFromConf(core.ProtoConf{
+		MaxConnections: 1,
+		Timeout:        time.Second,
+		DNSConf:        core.DNSConf{Hosts: map[string][]string{"canary.invalid": {"127.0.0.1"}}},
+	})}
+	item := core.PayloadItem{
+		Address: "http://canary.invalid:" + port,
+		Payload: []byte("GET / HTTP/1.1\r\nHost: canary.invalid\r\n\r\n"),
+	}
+
+	res := nib.Punch(&item)
+	if res.Error != nil || res.Status != 200 {
+		t.Errorf("Should reach pinned address: %v %d", res.Error, res.Status)
+	}
+}
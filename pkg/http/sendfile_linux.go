@@ -0,0 +1,51 @@
+package http
+
+import (
+	"golang.org/x/sys/unix"
+	"io"
+	"os"
+	"syscall"
+)
+
+const sendfileSupported = true
+
+// sendFile copies file region into socket in kernel, waiting for socket writability and respecting its deadline
+func sendFile(dst syscall.RawConn, file *os.File, offset int64, size int) (int, error) {
+	src, err := file.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	written := 0
+	var sendErr, writeErr error
+	err = src.Control(func(infd uintptr) {
+		writeErr = dst.Write(func(outfd uintptr) bool {
+			for written < size {
+				n, err := unix.Sendfile(int(outfd), int(infd), &offset, size-written)
+				if n > 0 {
+					written += n
+				}
+
+				switch {
+				case err == unix.EAGAIN:
+					return false // wait until socket is writable
+				case err == unix.EINTR:
+					continue
+				case err != nil:
+					sendErr = err
+					return true
+				case n == 0:
+					sendErr = io.ErrUnexpectedEOF // file was truncated
+					return true
+				}
+			}
+			return true
+		})
+	})
+	if err != nil {
+		return written, err
+	} else if writeErr != nil {
+		return written, writeErr
+	}
+	return written, sendErr
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package http
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+const sendfileSupported = false
+
+func sendFile(dst syscall.RawConn, file *os.File, offset int64, size int) (int, error) {
+	return 0, errors.New("sendfile is not supported on this platform")
+}
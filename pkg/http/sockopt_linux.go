@@ -0,0 +1,24 @@
+package http
+
+import (
+	"golang.org/x/sys/unix"
+	"syscall"
+)
+
+func setSockOpts(c syscall.RawConn, reuseAddr bool, bindNoPort bool) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		if reuseAddr {
+			sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEADDR, 1)
+		}
+
+		// lets kernel pick the port at connect time, so the same port can be used towards different destinations
+		if sockErr == nil && bindNoPort {
+			sockErr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_BIND_ADDRESS_NO_PORT, 1)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package http
+
+import (
+	"syscall"
+)
+
+func setSockOpts(c syscall.RawConn, reuseAddr bool, bindNoPort bool) error {
+	return nil // not supported, warned about at pool creation
+}
@@ -0,0 +1,60 @@
+package http
+
+import (
+	"encarno/pkg/core"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"runtime"
+	"sync/atomic"
+	"syscall"
+)
+
+// sourceDialers bind outgoing connections to local addresses, round-robin among the ones of target's IP family
+type sourceDialers struct {
+	v4   []*net.Dialer
+	v6   []*net.Dialer
+	next uint32
+}
+
+func newSourceDialers(base *net.Dialer, conf core.ProtoConf) *sourceDialers {
+	if (conf.ReuseAddr || conf.BindAddressNoPort) && runtime.GOOS != "linux" {
+		log.Warningf("Socket options for source addresses are only supported on Linux, ignoring them")
+	} else if conf.ReuseAddr || conf.BindAddressNoPort {
+		base.Control = func(network, address string, c syscall.RawConn) error {
+			return setSockOpts(c, conf.ReuseAddr, conf.BindAddressNoPort)
+		}
+	}
+
+	sources := &sourceDialers{}
+	for _, addr := range conf.SourceAddresses {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			panic(fmt.Sprintf("Source address is not an IP: %s", addr))
+		}
+
+		dialer := *base
+		dialer.LocalAddr = &net.TCPAddr{IP: ip}
+		if ip.To4() != nil {
+			sources.v4 = append(sources.v4, &dialer)
+		} else {
+			sources.v6 = append(sources.v6, &dialer)
+		}
+	}
+	return sources
+}
+
+// pick returns nil if there are no source addresses for target's family
+func (s *sourceDialers) pick(target net.IP) *net.Dialer {
+	dialers := s.v6
+	if target.To4() != nil {
+		dialers = s.v4
+	}
+
+	if len(dialers) == 0 {
+		return nil
+	}
+
+	n := atomic.AddUint32(&s.next, 1)
+	return dialers[int(n-1)%len(dialers)]
+}
@@ -0,0 +1,81 @@
+package http
+
+import (
+	"encarno/pkg/core"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSourceAddresses(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	remotes := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+			remotes <- host
+		}
+	}()
+
+	pool := NewPoolFromConf(core.ProtoConf{
+		Timeout:           1 * time.Second,
+		SourceAddresses:   []string{"127.0.0.2", "::1", "127.0.0.3"},
+		ReuseAddr:         true,
+		BindAddressNoPort: true,
+	})
+
+	expected := []string{"127.0.0.2", "127.0.0.3", "127.0.0.2"}
+	for _, exp := range expected {
+		conn, err := pool.Get(ln.Addr().String(), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		if remote := <-remotes; remote != exp {
+			t.Errorf("Expected connection from %s, got %s", exp, remote)
+		}
+	}
+}
+
+func TestSourceOptionsUnixSocket(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "test.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	pool := NewPoolFromConf(core.ProtoConf{
+		Timeout:           1 * time.Second,
+		SourceAddresses:   []string{"127.0.0.2"},
+		ReuseAddr:         true,
+		BindAddressNoPort: true,
+	})
+
+	conn, err := pool.Get("unix://"+sock, "")
+	if err != nil {
+		t.Fatalf("Source address options should not apply to unix socket: %s", err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,83 @@
+package http
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encarno/pkg/core"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
+	"os"
+)
+
+type hostCert struct {
+	host string
+	cert tls.Certificate
+}
+
+// newBaseTLSConfig prepares everything that does not depend on the host, it is cloned for each dialer
+func newBaseTLSConfig(conf core.TLSConf) (*tls.Config, []hostCert, error) {
+	tlsConfig := &tls.Config{
+		CipherSuites:       []uint16{},
+		InsecureSkipVerify: conf.InsecureSkipVerify,
+		MinVersion:         conf.MinVersion,
+		MaxVersion:         conf.MaxVersion,
+	}
+
+	for _, c := range tls.CipherSuites() {
+		if slices.Contains(conf.TLSCipherSuites, c.Name) {
+			tlsConfig.CipherSuites = append(tlsConfig.CipherSuites, c.ID)
+		}
+	}
+	for _, c := range tls.InsecureCipherSuites() {
+		if slices.Contains(conf.TLSCipherSuites, c.Name) {
+			tlsConfig.CipherSuites = append(tlsConfig.CipherSuites, c.ID)
+		}
+	}
+
+	if conf.RootCAFile != "" {
+		log.Infof("Loading root CA bundle: %s", conf.RootCAFile)
+		data, err := os.ReadFile(conf.RootCAFile)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, nil, errors.New(fmt.Sprintf("No certificates found in root CA file: %s", conf.RootCAFile))
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if conf.SessionCacheSize > 0 {
+		tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(conf.SessionCacheSize)
+	}
+
+	certs := make([]hostCert, 0)
+	for _, cc := range conf.ClientCerts {
+		log.Infof("Loading client certificate: %s", cc.CertFile)
+		cert, err := tls.LoadX509KeyPair(cc.CertFile, cc.KeyFile)
+		if err != nil {
+			return nil, nil, errors.New(fmt.Sprintf("Failed to load client certificate %s: %s", cc.CertFile, err))
+		}
+		certs = append(certs, hostCert{host: cc.Host, cert: cert})
+	}
+
+	return tlsConfig, certs, nil
+}
+
+// certForHost prefers the certificate configured for exact host, falling back to the one without host
+func certForHost(certs []hostCert, host string) []tls.Certificate {
+	var fallback []tls.Certificate
+	for _, hc := range certs {
+		if hc.host == host {
+			return []tls.Certificate{hc.cert}
+		}
+
+		if hc.host == "" && fallback == nil {
+			fallback = []tls.Certificate{hc.cert}
+		}
+	}
+	return fallback
+}
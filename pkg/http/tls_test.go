@@ -0,0 +1,142 @@
+package http
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encarno/pkg/core"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCert(t *testing.T, name string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	signer, signerKey := tpl, key
+	if parent == nil {
+		tpl.IsCA = true
+		tpl.BasicConstraintsValid = true
+		tpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+func (c *testCert) writePEM(t *testing.T, dir string, name string) (string, string) {
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+
+	keyDer, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0600)
+	if err == nil {
+		err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestConnPoolMTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "Test CA", nil)
+	caFile, _ := ca.writePEM(t, dir, "ca")
+	serverCert := newTestCert(t, "server.test", ca)
+	clientCert := newTestCert(t, "client.test", ca)
+	clientCertFile, clientKeyFile := clientCert.writePEM(t, dir, "client")
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello " + r.TLS.PeerCertificates[0].Subject.CommonName + " via " + r.TLS.ServerName))
+	}))
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{serverCert.der}, PrivateKey: serverCert.key}},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	tlsConf := core.TLSConf{
+		RootCAFile:       caFile,
+		ServerName:       "server.test",
+		SessionCacheSize: 10,
+		ClientCerts: []core.ClientCertConf{
+			{Host: "other.test", CertFile: caFile, KeyFile: clientKeyFile}, // mismatching pair, must not be loaded for our host
+		},
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("Mismatching certificate and key should panic")
+			}
+		}()
+		_ = NewConnectionPool(1, 1*time.Second, tlsConf)
+	}()
+
+	tlsConf.ClientCerts = []core.ClientCertConf{{CertFile: clientCertFile, KeyFile: clientKeyFile}}
+	nib := Nib{ConnPool: NewConnectionPool(1, 1*time.Second, tlsConf)}
+	asserts := []*core.AssertItem{{Re: &core.RegexpProxy{Regexp: regexp.MustCompile("hello client.test via server.test")}}}
+	for x := 0; x < 2; x++ {
+		item := core.PayloadItem{
+			Address: srv.URL,
+			Payload: []byte("GET / HTTP/1.1\r\nHost: server.test\r\nConnection: close\r\n\r\n"),
+			Asserts: asserts,
+		}
+		res := nib.Punch(&item)
+		if res.Error != nil {
+			t.Fatalf("Should not fail: %s", res.Error)
+		}
+
+		res.Assert(item.Asserts)
+		if res.Error != nil {
+			t.Errorf("Wrong response: %s\n%s", res.Error, res.RespBytes)
+		}
+	}
+}
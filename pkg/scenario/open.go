@@ -44,8 +44,16 @@ func (s *OpenWorkload) Run() {
 
 	s.SpawnInitial(scheduleChan)
 
+	var schedule core.ScheduleChannel
+	if s.InputSchedule != nil {
+		log.Infof("Using schedule recorded in input")
+		schedule = s.InputSchedule()
+	} else {
+		schedule = s.GenerateSchedule()
+	}
+
 	last := time.Duration(0)
-	for offset := range s.GenerateSchedule() {
+	for offset := range schedule {
 		if s.interrupted {
 			break
 		}
@@ -56,7 +64,7 @@ func (s *OpenWorkload) Run() {
 			last = offset
 		}
 
-		if time.Now().After(stopCutoff) {
+		if s.sumDurations > 0 && time.Now().After(stopCutoff) {
 			log.Warningf("The test exceeds expected duration of %v, interrupting...", s.sumDurations)
 			break
 		}
@@ -75,6 +83,13 @@ func (s *OpenWorkload) Run() {
 		}
 	}
 
+	if s.InputSchedule != nil { // let workers wake up for the last recorded offsets
+		end := s.StartTime.Add(last)
+		for !s.interrupted && time.Now().Before(end) {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
 	// TODO: make sure workers have finished before exiting
 
 	close(scheduleChan)
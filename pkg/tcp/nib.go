@@ -0,0 +1,192 @@
+package tcp
+
+import (
+	"bytes"
+	"encarno/pkg/core"
+	"encarno/pkg/http"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"time"
+)
+
+// StatusOK is reported when the response was read completely
+const StatusOK = 200
+
+// Options is the driver-specific section of protocol config
+type Options struct {
+	Terminator   string        // response is complete when this sequence is read
+	ResponseSize int           // response is complete when this many bytes are read
+	IdleTimeout  time.Duration // response is complete when nothing is read for this long
+}
+
+func (o *Options) Validate() error {
+	if o.ResponseSize < 0 {
+		return errors.New("responsesize cannot be negative")
+	}
+
+	if o.IdleTimeout < 0 {
+		return errors.New("idletimeout cannot be negative")
+	}
+	return nil
+}
+
+func init() {
+	core.RegisterNib("tcp", core.NibDriver{
+		Description: "raw TCP payloads, response is read until terminator, size or idle timeout",
+		Options: func() interface{} {
+			return &Options{}
+		},
+		Factory: func(conf core.ProtoConf, options interface{}) (core.NibMaker, error) {
+			opts := options.(*Options)
+			pool := http.NewPoolFromConf(conf)
+
+			return func() core.Nib {
+				return &Nib{
+					ConnPool:     pool,
+					Terminator:   []byte(opts.Terminator),
+					ResponseSize: opts.ResponseSize,
+					IdleTimeout:  opts.IdleTimeout,
+				}
+			}, nil
+		},
+	})
+}
+
+// Nib writes payload bytes verbatim and reads the response until one of configured conditions is met
+type Nib struct {
+	ConnPool     *http.ConnPool
+	Terminator   []byte
+	ResponseSize int
+	IdleTimeout  time.Duration
+}
+
+func (n *Nib) Punch(item *core.PayloadItem) *core.OutputItem {
+	outItem := core.NewOutputItem()
+	outItem.StartTime = time.Now()
+
+	before := time.Now()
+	conn, err := n.ConnPool.Get(item.Address, "")
+	connected := time.Now()
+	outItem.ConnectTime = connected.Sub(before)
+	if err != nil {
+		outItem.EndWithError(err)
+		return outItem
+	}
+	conn.Times.Fill(outItem)
+
+	if len(item.RegexOut) > 0 || len(item.Asserts) > 0 {
+		conn.ReadRecordLimit = 0
+	} else {
+		conn.ReadRecordLimit = 1024 * 1024
+	}
+
+	deadline := time.Now().Add(n.ConnPool.Timeout)
+	if err := conn.SetDeadline(deadline); err != nil {
+		outItem.EndWithError(err)
+		conn.Close()
+		return outItem
+	}
+
+	log.Debugf("Writing %d bytes into connection", len(item.Payload))
+	write, err := conn.WritePayload(item, item.Payload)
+	outItem.SentBytesCount = uint64(write)
+	outItem.SentTime = time.Now().Sub(connected)
+	if err != nil {
+		outItem.EndWithError(err)
+		conn.Close()
+		return outItem
+	}
+
+	begin := time.Now()
+	reusable, err := n.readResponse(conn, deadline)
+	finish := time.Now()
+	if !conn.FirstRead.IsZero() {
+		outItem.FirstByteTime = conn.FirstRead.Sub(begin)
+		outItem.ReadTime = finish.Sub(conn.FirstRead)
+	}
+	outItem.Elapsed = finish.Sub(outItem.StartTime)
+	outItem.RespBytesCount = uint64(conn.ReadLen)
+	outItem.SetRespBuffer(conn.TakeRecorded())
+
+	if err != nil {
+		outItem.EndWithError(err)
+		conn.Close()
+		return outItem
+	}
+
+	outItem.Status = StatusOK
+	if reusable {
+		n.ConnPool.Return(item.Address, conn)
+	} else {
+		go conn.Close()
+	}
+	return outItem
+}
+
+// readResponse returns false if connection cannot be used anymore
+func (n *Nib) readResponse(conn *http.BufferedConn, deadline time.Time) (bool, error) {
+	if len(n.Terminator) == 0 && n.ResponseSize <= 0 && n.IdleTimeout <= 0 {
+		return true, nil // fire and forget
+	}
+
+	buf := make([]byte, 4096)
+	window := make([]byte, 0)
+	total := 0
+	for {
+		if n.IdleTimeout > 0 {
+			idleDeadline := time.Now().Add(n.IdleTimeout)
+			if idleDeadline.After(deadline) {
+				idleDeadline = deadline
+			}
+
+			if err := conn.SetReadDeadline(idleDeadline); err != nil {
+				return false, err
+			}
+		}
+
+		chunk := buf
+		if n.ResponseSize > 0 && n.ResponseSize-total < len(chunk) {
+			chunk = chunk[:n.ResponseSize-total]
+		}
+
+		read, err := conn.BufReader.Read(chunk)
+		total += read
+		if err != nil {
+			return n.handleReadErr(err, total)
+		}
+
+		if n.ResponseSize > 0 && total >= n.ResponseSize {
+			return true, nil
+		}
+
+		if len(n.Terminator) > 0 {
+			window = append(window, chunk[:read]...)
+			if bytes.Contains(window, n.Terminator) {
+				return true, nil
+			}
+
+			if len(window) >= len(n.Terminator) {
+				window = window[len(window)-len(n.Terminator)+1:]
+			}
+		}
+	}
+}
+
+func (n *Nib) handleReadErr(err error, total int) (bool, error) {
+	timedOut := errors.Is(err, os.ErrDeadlineExceeded)
+	if n.IdleTimeout > 0 && timedOut && total > 0 {
+		return false, nil // idle timeout is a normal end of response
+	}
+
+	if err == io.EOF && len(n.Terminator) == 0 && n.ResponseSize <= 0 {
+		return false, nil // server closed the connection, that's the only delimiter we have
+	}
+
+	if timedOut {
+		return false, errors.New(fmt.Sprintf("Response was not complete after reading %d bytes: %s", total, err))
+	}
+	return false, err
+}
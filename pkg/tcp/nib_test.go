@@ -0,0 +1,99 @@
+package tcp
+
+import (
+	"bufio"
+	"encarno/pkg/core"
+	"encarno/pkg/http"
+	"net"
+	"testing"
+	"time"
+)
+
+// answers each line with "echo: <line>", closing connection on "bye"
+func lineServer(t *testing.T) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					_, _ = conn.Write([]byte("echo: " + line))
+					if line == "bye\n" {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return ln
+}
+
+func TestNibTerminator(t *testing.T) {
+	ln := lineServer(t)
+	defer ln.Close()
+
+	nib := Nib{
+		ConnPool:   http.NewConnectionPool(1, 1*time.Second, core.TLSConf{}),
+		Terminator: []byte("\n"),
+	}
+
+	address := "tcp://" + ln.Addr().String()
+	for _, line := range []string{"one\n", "two\n"} {
+		res := nib.Punch(&core.PayloadItem{Address: address, Payload: []byte(line)})
+		if res.Error != nil {
+			t.Fatalf("Should not fail: %s", res.Error)
+		}
+		if string(res.RespBytes) != "echo: "+line {
+			t.Errorf("Wrong response: %q", res.RespBytes)
+		}
+	}
+
+	if nib.ConnPool.IdleCount(address) != 1 {
+		t.Errorf("Connection should be reused")
+	}
+}
+
+func TestNibSizeAndIdle(t *testing.T) {
+	ln := lineServer(t)
+	defer ln.Close()
+
+	nib := Nib{
+		ConnPool:     http.NewConnectionPool(1, 1*time.Second, core.TLSConf{}),
+		ResponseSize: 4,
+	}
+
+	res := nib.Punch(&core.PayloadItem{Address: ln.Addr().String(), Payload: []byte("size\n")})
+	if res.Error != nil || res.RespBytesCount < 4 {
+		t.Errorf("Unexpected result: %d %v", res.RespBytesCount, res.Error)
+	}
+
+	nib = Nib{
+		ConnPool:    http.NewConnectionPool(1, 1*time.Second, core.TLSConf{}),
+		IdleTimeout: 50 * time.Millisecond,
+	}
+
+	res = nib.Punch(&core.PayloadItem{Address: ln.Addr().String(), Payload: []byte("idle\n")})
+	if res.Error != nil || string(res.RespBytes) != "echo: idle\n" {
+		t.Errorf("Unexpected result: %q %v", res.RespBytes, res.Error)
+	}
+
+	nib.Terminator = []byte("never")
+	res = nib.Punch(&core.PayloadItem{Address: ln.Addr().String(), Payload: []byte("again\n")})
+	if res.Error != nil {
+		t.Errorf("Idle timeout should complete the response: %v", res.Error)
+	}
+}
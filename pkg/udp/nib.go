@@ -0,0 +1,151 @@
+package udp
+
+import (
+	"context"
+	"encarno/pkg/core"
+	"encarno/pkg/http"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"strings"
+	"time"
+)
+
+// StatusOK is reported when datagram was sent, and reply was received if needed
+const StatusOK = 200
+
+const maxDatagram = 65535
+
+// Options is the driver-specific section of protocol config
+type Options struct {
+	WaitReply bool // wait for single reply datagram
+}
+
+func init() {
+	core.RegisterNib("udp", core.NibDriver{
+		Description: "one datagram per payload, optionally waiting for reply",
+		Options: func() interface{} {
+			return &Options{}
+		},
+		Factory: func(conf core.ProtoConf, options interface{}) (core.NibMaker, error) {
+			opts := options.(*Options)
+			resolver := http.NewResolver(conf.DNSConf)
+
+			return func() core.Nib {
+				return &Nib{
+					Timeout:   conf.Timeout,
+					WaitReply: opts.WaitReply,
+					Resolver:  resolver,
+				}
+			}, nil
+		},
+	})
+}
+
+// Nib sends one datagram per payload item, host is resolved for each of them, so DNS balancing spreads datagrams.
+// Own socket is kept per resolved address.
+type Nib struct {
+	Timeout   time.Duration
+	WaitReply bool
+	Resolver  *http.Resolver
+	conns     map[string]net.Conn
+	buf       []byte
+}
+
+func (n *Nib) Punch(item *core.PayloadItem) *core.OutputItem {
+	outItem := core.NewOutputItem()
+	outItem.StartTime = time.Now()
+
+	before := time.Now()
+	conn, target, err := n.getConn(item.Address)
+	connected := time.Now()
+	outItem.ConnectTime = connected.Sub(before)
+	if err != nil {
+		outItem.EndWithError(err)
+		return outItem
+	}
+
+	if err := conn.SetDeadline(time.Now().Add(n.Timeout)); err != nil {
+		n.fail(target, outItem, err)
+		return outItem
+	}
+
+	log.Debugf("Sending datagram of %d bytes", len(item.Payload))
+	write, err := conn.Write(item.Payload)
+	outItem.SentBytesCount = uint64(write)
+	outItem.SentTime = time.Now().Sub(connected)
+	if err != nil {
+		n.fail(target, outItem, err)
+		return outItem
+	}
+
+	if n.WaitReply {
+		if n.buf == nil {
+			n.buf = make([]byte, maxDatagram)
+		}
+
+		begin := time.Now()
+		read, err := conn.Read(n.buf)
+		outItem.FirstByteTime = time.Now().Sub(begin)
+		if err != nil {
+			n.fail(target, outItem, err)
+			return outItem
+		}
+
+		outItem.RespBytesCount = uint64(read)
+		buf := core.GetBuffer()
+		buf.Write(n.buf[:read])
+		outItem.SetRespBuffer(buf)
+	}
+
+	outItem.Elapsed = time.Now().Sub(outItem.StartTime)
+	outItem.Status = StatusOK
+	return outItem
+}
+
+func (n *Nib) getConn(address string) (net.Conn, string, error) {
+	host, port, err := net.SplitHostPort(strings.TrimPrefix(address, "udp://"))
+	if err != nil {
+		return nil, "", errors.New(fmt.Sprintf("UDP address has to contain host and port, got '%s': %s", address, err))
+	}
+
+	if n.Resolver == nil {
+		n.Resolver = http.NewResolver(core.DNSConf{})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), n.Timeout)
+	defer cancel()
+	ips, err := n.Resolver.Resolve(ctx, host)
+	if err != nil {
+		return nil, "", err
+	}
+
+	target := net.JoinHostPort(ips[0].String(), port)
+	if conn, ok := n.conns[target]; ok {
+		return conn, target, nil
+	}
+
+	if n.conns == nil {
+		n.conns = map[string]net.Conn{}
+	}
+
+	log.Debugf("Opening UDP socket to %s", target)
+	conn, err := net.DialTimeout("udp", target, n.Timeout)
+	if err != nil {
+		return nil, target, err
+	}
+
+	n.conns[target] = conn
+	return conn, target, nil
+}
+
+// fail drops the socket, since ICMP errors may leave it in a bad state
+func (n *Nib) fail(target string, outItem *core.OutputItem, err error) {
+	outItem.Elapsed = time.Now().Sub(outItem.StartTime)
+	outItem.EndWithError(err)
+	if conn, ok := n.conns[target]; ok {
+		_ = conn.Close()
+		delete(n.conns, target)
+	}
+}
@@ -0,0 +1,86 @@
+package udp
+
+import (
+	"encarno/pkg/core"
+	"encarno/pkg/http"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestNib(t *testing.T) {
+	srv, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	go func() {
+		buf := make([]byte, maxDatagram)
+		for {
+			n, addr, err := srv.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = srv.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	nib := Nib{Timeout: 1 * time.Second}
+	res := nib.Punch(&core.PayloadItem{Address: srv.LocalAddr().String(), Payload: []byte("metric:1|c")})
+	if res.Error != nil || res.Status != StatusOK {
+		t.Errorf("Unexpected result: %d %v", res.Status, res.Error)
+	}
+
+	nib = Nib{Timeout: 1 * time.Second, WaitReply: true} // socket of the first one has echo of metric waiting
+	res = nib.Punch(&core.PayloadItem{Address: "udp://" + srv.LocalAddr().String(), Payload: []byte("ping")})
+	if res.Error != nil || string(res.RespBytes) != "ping" {
+		t.Errorf("Unexpected result: %q %v", res.RespBytes, res.Error)
+	}
+
+	res = nib.Punch(&core.PayloadItem{Address: "localhost", Payload: []byte("ping")})
+	if res.Error == nil {
+		t.Errorf("Should fail without port")
+	}
+}
+
+func TestNibBalance(t *testing.T) {
+	first, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+
+	_, port, _ := net.SplitHostPort(first.LocalAddr().String())
+	second, err := net.ListenPacket("udp", "127.0.0.2:"+port)
+	if err != nil {
+		t.Skipf("Second loopback address is not available: %s", err)
+	}
+	defer second.Close()
+
+	nib := Nib{
+		Timeout:  1 * time.Second,
+		Resolver: http.NewResolver(core.DNSConf{Hosts: map[string][]string{"metrics": {"127.0.0.1", "127.0.0.2"}}, Balance: http.BalanceRoundRobin}),
+	}
+
+	for i := 0; i < 4; i++ {
+		res := nib.Punch(&core.PayloadItem{Address: "metrics:" + port, Payload: []byte("metric:1|c")})
+		if res.Error != nil {
+			t.Fatal(res.Error)
+		}
+	}
+
+	if len(nib.conns) != 2 {
+		t.Errorf("Should keep socket per resolved address: %d", len(nib.conns))
+	}
+
+	for _, srv := range []net.PacketConn{first, second} {
+		buf := make([]byte, maxDatagram)
+		for i := 0; i < 2; i++ {
+			_ = srv.SetReadDeadline(time.Now().Add(time.Second))
+			if _, _, err := srv.ReadFrom(buf); err != nil {
+				t.Errorf("Datagrams should be spread between addresses: %s", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package websocket
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+type frame struct {
+	fin     bool
+	opcode  byte
+	payload []byte
+}
+
+// appendFrame encodes client frame, which always has to be masked
+func appendFrame(buf []byte, opcode byte, payload []byte) []byte {
+	buf = append(buf, 0x80|opcode)
+
+	plen := len(payload)
+	switch {
+	case plen < 126:
+		buf = append(buf, 0x80|byte(plen))
+	case plen <= 0xFFFF:
+		buf = append(buf, 0x80|126, 0, 0)
+		binary.BigEndian.PutUint16(buf[len(buf)-2:], uint16(plen))
+	default:
+		buf = append(buf, 0x80|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(buf[len(buf)-8:], uint64(plen))
+	}
+
+	mask := make([]byte, 4)
+	if _, err := rand.Read(mask); err != nil {
+		panic(err)
+	}
+	buf = append(buf, mask...)
+
+	start := len(buf)
+	buf = append(buf, payload...)
+	for i := range payload {
+		buf[start+i] ^= mask[i%4]
+	}
+	return buf
+}
+
+// readFrame fails on frames with payload longer than limit, before allocating it
+func readFrame(r io.Reader, limit uint64) (*frame, error) {
+	hdr := make([]byte, 2, 8)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+
+	f := &frame{
+		fin:    hdr[0]&0x80 != 0,
+		opcode: hdr[0] & 0x0F,
+	}
+
+	masked := hdr[1]&0x80 != 0
+	plen := uint64(hdr[1] & 0x7F)
+	switch plen {
+	case 126:
+		ext := hdr[:2]
+		if _, err := io.ReadFull(r, ext); err != nil {
+			return nil, err
+		}
+		plen = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := hdr[:8]
+		if _, err := io.ReadFull(r, ext); err != nil {
+			return nil, err
+		}
+		plen = binary.BigEndian.Uint64(ext)
+	}
+
+	if plen > limit {
+		return nil, errors.New(fmt.Sprintf("WebSocket frame is too large: %d > %d", plen, limit))
+	}
+
+	var mask []byte
+	if masked {
+		mask = make([]byte, 4)
+		if _, err := io.ReadFull(r, mask); err != nil {
+			return nil, err
+		}
+	}
+
+	f.payload = make([]byte, plen)
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		return nil, err
+	}
+
+	if masked {
+		for i := range f.payload {
+			f.payload[i] ^= mask[i%4]
+		}
+	}
+	return f, nil
+}
@@ -0,0 +1,328 @@
+package websocket
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"encarno/pkg/core"
+	"encarno/pkg/http"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	gohttp "net/http"
+	"strings"
+	"time"
+)
+
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// StatusSwitched is reported for handshake and for every message exchanged over the upgraded connection
+const StatusSwitched = 101
+
+// DefaultMaxMessageSize limits messages read from server, unless configured otherwise
+const DefaultMaxMessageSize = 16 << 20
+
+// Options is the driver-specific section of protocol config
+type Options struct {
+	MessageType    string // "text" or "binary", frame type for sent messages
+	MaxMessageSize int    // longer messages from server are failed without reading them
+}
+
+func (o *Options) Validate() error {
+	if o.MessageType != "text" && o.MessageType != "binary" {
+		return errors.New(fmt.Sprintf("messagetype has to be 'text' or 'binary', got '%s'", o.MessageType))
+	}
+
+	if o.MaxMessageSize <= 0 {
+		return errors.New("maxmessagesize has to be positive")
+	}
+	return nil
+}
+
+func init() {
+	core.RegisterNib("websocket", core.NibDriver{
+		Description: "WebSocket sessions, payload is either handshake request or a message",
+		Options: func() interface{} {
+			return &Options{MessageType: "text", MaxMessageSize: DefaultMaxMessageSize}
+		},
+		Factory: func(conf core.ProtoConf, options interface{}) (core.NibMaker, error) {
+			opts := options.(*Options)
+			pool := http.NewPoolFromConf(conf)
+
+			return func() core.Nib {
+				return &Nib{
+					ConnPool:       pool,
+					Binary:         opts.MessageType == "binary",
+					MaxMessageSize: opts.MaxMessageSize,
+				}
+			}, nil
+		},
+	})
+}
+
+// Nib keeps the current socket of its worker, payload item is either a handshake request or a message to send
+type Nib struct {
+	ConnPool       *http.ConnPool
+	Binary         bool // send messages in binary frames instead of text ones
+	MaxMessageSize int  // zero means DefaultMaxMessageSize
+	conn           *http.BufferedConn
+}
+
+func (n *Nib) Punch(item *core.PayloadItem) *core.OutputItem {
+	outItem := core.NewOutputItem()
+	outItem.StartTime = time.Now()
+
+	if IsHandshake(item.Payload) {
+		n.handshake(item, outItem)
+	} else {
+		n.message(item, outItem)
+	}
+
+	outItem.Elapsed = time.Now().Sub(outItem.StartTime)
+	return outItem
+}
+
+// IsHandshake tells if payload is HTTP Upgrade request rather than a message
+func IsHandshake(payload []byte) bool {
+	if !bytes.HasPrefix(payload, []byte("GET ")) {
+		return false
+	}
+
+	lines, _, _ := splitHead(payload)
+	return strings.EqualFold(headerValue(lines, "Upgrade"), "websocket")
+}
+
+func (n *Nib) handshake(item *core.PayloadItem, outItem *core.OutputItem) {
+	n.Close()
+
+	payload, key := ensureKey(item.Payload)
+	item.Payload = payload
+
+	before := time.Now()
+	lines, _, _ := splitHead(payload)
+	conn, err := n.ConnPool.Get(item.Address, headerValue(lines, "Host"))
+	connected := time.Now()
+	outItem.ConnectTime = connected.Sub(before)
+	if err != nil {
+		outItem.EndWithError(err)
+		return
+	}
+	conn.Times.Fill(outItem)
+
+	if err := conn.SetDeadline(time.Now().Add(n.ConnPool.Timeout)); err != nil {
+		outItem.EndWithError(err)
+		conn.Close()
+		return
+	}
+
+	write, err := conn.Write(payload)
+	outItem.SentBytesCount = uint64(write)
+	outItem.SentTime = time.Now().Sub(connected)
+	if err != nil {
+		outItem.EndWithError(err)
+		conn.Close()
+		return
+	}
+
+	begin := time.Now()
+	resp, err := gohttp.ReadResponse(conn.BufReader, nil)
+	finish := time.Now()
+	if !conn.FirstRead.IsZero() {
+		outItem.FirstByteTime = conn.FirstRead.Sub(begin)
+		outItem.ReadTime = finish.Sub(conn.FirstRead)
+	}
+	outItem.RespBytesCount = uint64(conn.ReadLen)
+	if err != nil {
+		outItem.EndWithError(err)
+		conn.Close()
+		return
+	}
+
+	outItem.Status = uint16(resp.StatusCode)
+	outItem.SetRespBuffer(conn.TakeRecorded())
+
+	if resp.StatusCode != gohttp.StatusSwitchingProtocols {
+		outItem.Error = errors.New(fmt.Sprintf("WebSocket handshake was not accepted: %s", resp.Status))
+		conn.Close()
+		return
+	}
+
+	if resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		outItem.Error = errors.New("WebSocket handshake has wrong Sec-WebSocket-Accept")
+		conn.Close()
+		return
+	}
+
+	n.ConnPool.Detach(conn) // session holds the socket until closed, it should not count against pool limit
+	n.conn = conn
+}
+
+func (n *Nib) message(item *core.PayloadItem, outItem *core.OutputItem) {
+	if n.conn == nil {
+		outItem.EndWithError(errors.New("No WebSocket connection, handshake payload has to go first"))
+		return
+	}
+
+	conn := n.conn
+	conn.Reset()
+	if err := conn.SetDeadline(time.Now().Add(n.ConnPool.Timeout)); err != nil {
+		n.fail(outItem, err)
+		return
+	}
+
+	// empty payload means we just wait for the next message from server
+	if len(item.Payload) > 0 {
+		opcode := byte(opText)
+		if n.Binary {
+			opcode = opBinary
+		}
+
+		write, err := conn.Write(appendFrame(nil, opcode, item.Payload))
+		outItem.SentBytesCount = uint64(write)
+		if err != nil {
+			n.fail(outItem, err)
+			return
+		}
+	}
+	outItem.SentTime = time.Now().Sub(outItem.StartTime)
+
+	begin := time.Now()
+	msg, err := n.readMessage()
+	finish := time.Now()
+	if !conn.FirstRead.IsZero() {
+		outItem.FirstByteTime = conn.FirstRead.Sub(begin)
+		outItem.ReadTime = finish.Sub(conn.FirstRead)
+	}
+	outItem.RespBytesCount = uint64(conn.ReadLen)
+	if err != nil {
+		n.fail(outItem, err)
+		return
+	}
+
+	outItem.Status = StatusSwitched
+	outItem.RespBytes = msg
+}
+
+// readMessage reads frames until complete data message, answering control frames on the way
+func (n *Nib) readMessage() ([]byte, error) {
+	limit := n.MaxMessageSize
+	if limit <= 0 {
+		limit = DefaultMaxMessageSize
+	}
+
+	msg := make([]byte, 0)
+	for {
+		budget := limit - len(msg)
+		if budget < 125 { // control frames can come in between
+			budget = 125
+		}
+
+		f, err := readFrame(n.conn.BufReader, uint64(budget))
+		if err != nil {
+			return nil, err
+		}
+
+		switch f.opcode {
+		case opPing:
+			if _, err := n.conn.Write(appendFrame(nil, opPong, f.payload)); err != nil {
+				return nil, err
+			}
+		case opPong:
+			continue
+		case opClose:
+			code := uint16(0)
+			if len(f.payload) >= 2 {
+				code = binary.BigEndian.Uint16(f.payload)
+			}
+			_, _ = n.conn.Write(appendFrame(nil, opClose, f.payload[:0]))
+			return nil, errors.New(fmt.Sprintf("WebSocket closed by server with code %d", code))
+		default:
+			msg = append(msg, f.payload...)
+			if len(msg) > limit {
+				return nil, errors.New(fmt.Sprintf("WebSocket message is too large: %d > %d", len(msg), limit))
+			}
+
+			if f.fin {
+				return msg, nil
+			}
+		}
+	}
+}
+
+func (n *Nib) fail(outItem *core.OutputItem, err error) {
+	outItem.EndWithError(err)
+	n.conn.Close()
+	n.conn = nil
+}
+
+// Close sends close frame for the current socket, if there is any
+func (n *Nib) Close() {
+	if n.conn == nil {
+		return
+	}
+
+	log.Debugf("Closing WebSocket connection")
+	closeMsg := []byte{0x03, 0xE8} // 1000 is normal closure
+	_, _ = n.conn.Write(appendFrame(nil, opClose, closeMsg))
+	n.conn.Close()
+	n.conn = nil
+}
+
+// ensureKey adds mandatory handshake headers if payload does not have them
+func ensureKey(payload []byte) ([]byte, string) {
+	lines, eol, body := splitHead(payload)
+	key := headerValue(lines, "Sec-WebSocket-Key")
+	hasVersion := headerValue(lines, "Sec-WebSocket-Version") != ""
+	if key != "" && hasVersion {
+		return payload, key
+	}
+
+	if key == "" {
+		nonce := make([]byte, 16)
+		if _, err := rand.Read(nonce); err != nil {
+			panic(err)
+		}
+		key = base64.StdEncoding.EncodeToString(nonce)
+		lines = append(lines, "Sec-WebSocket-Key: "+key)
+	}
+	if !hasVersion {
+		lines = append(lines, "Sec-WebSocket-Version: 13")
+	}
+
+	buf := bytes.Buffer{}
+	for _, line := range lines {
+		buf.WriteString(line + eol)
+	}
+	buf.WriteString(eol)
+	buf.Write(body)
+	return buf.Bytes(), key
+}
+
+// splitHead returns request line with headers, the line separator used and the body
+func splitHead(payload []byte) ([]string, string, []byte) {
+	eol := "\n"
+	if bytes.Contains(payload, []byte("\r\n")) {
+		eol = "\r\n"
+	}
+
+	head, body, _ := bytes.Cut(payload, []byte(eol+eol))
+	return strings.Split(string(head), eol), eol, body
+}
+
+func headerValue(lines []string, header string) string {
+	for _, line := range lines[1:] {
+		name, value, _ := strings.Cut(line, ":")
+		if strings.EqualFold(strings.TrimSpace(name), header) {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+func acceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,166 @@
+package websocket
+
+import (
+	"encarno/pkg/core"
+	"encarno/pkg/http"
+	"fmt"
+	xws "golang.org/x/net/websocket"
+	"io"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNib(t *testing.T) {
+	srv := httptest.NewServer(xws.Handler(func(ws *xws.Conn) {
+		for {
+			var msg string
+			if err := xws.Message.Receive(ws, &msg); err != nil {
+				return
+			}
+			_ = xws.Message.Send(ws, msg)
+		}
+	}))
+	defer srv.Close()
+
+	nib := Nib{
+		ConnPool: http.NewConnectionPool(1, 1*time.Second, core.TLSConf{}),
+	}
+
+	res := nib.Punch(&core.PayloadItem{
+		Address: srv.URL,
+		Payload: []byte("GET / HTTP/1.1\nHost: localhost\nUpgrade: websocket\nConnection: Upgrade\nOrigin: http://localhost\n\n"),
+	})
+	if res.Error != nil {
+		t.Fatalf("Handshake failed: %s", res.Error)
+	}
+	if res.Status != StatusSwitched {
+		t.Errorf("Wrong status: %d", res.Status)
+	}
+
+	vals := core.ValMap{}
+	for _, msg := range []string{"hello 123", strings.Repeat("long", 20000)} {
+		res = nib.Punch(&core.PayloadItem{Payload: []byte(msg)})
+		if res.Error != nil {
+			t.Fatalf("Message failed: %s", res.Error)
+		}
+		if string(res.RespBytes) != msg {
+			t.Errorf("Wrong echo: %d bytes", len(res.RespBytes))
+		}
+
+		res.ExtractValues(map[string]*core.ExtractRegex{"num": {Re: &core.RegexpProxy{Regexp: regexp.MustCompile("\\d+")}}}, vals)
+	}
+
+	if string(vals["num"]) != "NOT_FOUND" {
+		t.Errorf("Last message has no digits: %s", vals["num"])
+	}
+
+	nib.Close()
+	res = nib.Punch(&core.PayloadItem{Payload: []byte("after close")})
+	if res.Error == nil {
+		t.Errorf("Should fail without handshake")
+	}
+}
+
+func TestIsHandshake(t *testing.T) {
+	if !IsHandshake([]byte("GET /ws HTTP/1.1\r\nupgrade: WebSocket\r\n\r\n")) {
+		t.Errorf("Should detect handshake")
+	}
+
+	if IsHandshake([]byte("GET /ws HTTP/1.1\r\n\r\n")) {
+		t.Errorf("Plain request is not a handshake")
+	}
+}
+
+func TestNibOptions(t *testing.T) {
+	typeCodec := xws.Codec{
+		Marshal: func(v interface{}) ([]byte, byte, error) {
+			return []byte(v.(string)), xws.TextFrame, nil
+		},
+		Unmarshal: func(data []byte, payloadType byte, v interface{}) error {
+			*(v.(*string)) = fmt.Sprintf("%d %s", payloadType, data)
+			return nil
+		},
+	}
+
+	srv := httptest.NewServer(xws.Handler(func(ws *xws.Conn) {
+		for {
+			var msg string
+			if err := typeCodec.Receive(ws, &msg); err != nil {
+				return
+			}
+			_ = typeCodec.Send(ws, msg+strings.Repeat(".", 200))
+		}
+	}))
+	defer srv.Close()
+
+	handshake := &core.PayloadItem{
+		Address: srv.URL,
+		Payload: []byte("GET / HTTP/1.1\nHost: localhost\nUpgrade: websocket\nConnection: Upgrade\nOrigin: http://localhost\n\n"),
+	}
+
+	for _, binary := range []bool{false, true} {
+		nib := Nib{
+			ConnPool: http.NewConnectionPool(1, 1*time.Second, core.TLSConf{}),
+			Binary:   binary,
+		}
+
+		if res := nib.Punch(handshake); res.Error != nil {
+			t.Fatalf("Handshake failed: %s", res.Error)
+		}
+
+		res := nib.Punch(&core.PayloadItem{Payload: []byte("msg")})
+		expected := fmt.Sprintf("%d msg", map[bool]int{false: opText, true: opBinary}[binary])
+		if res.Error != nil || !strings.HasPrefix(string(res.RespBytes), expected) {
+			t.Errorf("Message should be sent with configured type %s: %v", expected, res.RespBytes)
+		}
+		nib.Close()
+	}
+
+	nib := Nib{
+		ConnPool:       http.NewConnectionPool(1, 1*time.Second, core.TLSConf{}),
+		MaxMessageSize: 50,
+	}
+
+	if res := nib.Punch(handshake); res.Error != nil {
+		t.Fatalf("Handshake failed: %s", res.Error)
+	}
+
+	res := nib.Punch(&core.PayloadItem{Payload: []byte("msg")})
+	if res.Error == nil || !strings.Contains(res.Error.Error(), "too large") {
+		t.Errorf("Message above size limit should fail, got: %v", res.Error)
+	}
+}
+
+func TestOptionsValidate(t *testing.T) {
+	if err := (&Options{MessageType: "auto", MaxMessageSize: 1}).Validate(); err == nil {
+		t.Errorf("Unknown message type should be rejected")
+	}
+
+	if err := (&Options{MessageType: "binary"}).Validate(); err == nil {
+		t.Errorf("Zero message size limit should be rejected")
+	}
+}
+
+func TestNibSessionsOverLimit(t *testing.T) {
+	srv := httptest.NewServer(xws.Handler(func(ws *xws.Conn) {
+		_, _ = io.Copy(ws, ws)
+	}))
+	defer srv.Close()
+
+	pool := http.NewConnectionPool(1, 1*time.Second, core.TLSConf{})
+	pool.WaitTimeout = 100 * time.Millisecond
+	for x := 0; x < 3; x++ {
+		nib := Nib{ConnPool: pool}
+		res := nib.Punch(&core.PayloadItem{
+			Address: srv.URL,
+			Payload: []byte("GET / HTTP/1.1\nHost: localhost\nUpgrade: websocket\nConnection: Upgrade\nOrigin: http://localhost\n\n"),
+		})
+		if res.Error != nil {
+			t.Fatalf("Open session should not hold pool slot, handshake %d failed: %s", x, res.Error)
+		}
+		defer nib.Close()
+	}
+}
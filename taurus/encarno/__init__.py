@@ -22,6 +22,10 @@ from bzt.utils import RequiredTool, FileReader, shutdown_process, BetterDict, de
 from bzt.utils import get_full_path, CALL_PROBLEMS
 
 
+# variables like ${var}, template functions like ${:random-int:1:100:}, both with optional filters like ${var|base64}
+TEMPLATE_RE = re.compile(r'\$\{([A-Za-z]\w+(?:\|\w+)*|:[\w-]+:(?:[^}|]*:)?(?:\|\w+)*)}')
+
+
 class EncarnoExecutor(ScenarioExecutor, HavingInstallableTools):
     def __init__(self):
         super().__init__()
@@ -88,6 +92,8 @@ class EncarnoExecutor(ScenarioExecutor, HavingInstallableTools):
 
     def post_process(self):
         super().post_process()
+        if getattr(self, "reader", None) is not None and self.reader.breakdown.count:
+            self.log.info("Encarno new connections: %s", self.reader.breakdown)
         if self._get_stderr():
             self.log.warning("Encarno STDERR contains some messages, please check it out: %s", self.stderr.name)
 
@@ -217,13 +223,29 @@ class EncarnoFilesGenerator(object):
                 "driver": scenario.get('protocol', 'http'),
                 "timeout": "%ss" % timeout,
                 "maxconnections": load.concurrency,
-                "tlsconf": scenario.get("tls-config", self.settings.get("tls-config", {}))
+                "tlsconf": scenario.get("tls-config", self.settings.get("tls-config", {})),
+                "dnsconf": scenario.get("dns-config", self.settings.get("dns-config", {})),
+                "sourceaddresses": scenario.get("source-addresses", self.settings.get("source-addresses", [])),
+                "proxy": scenario.get("proxy", self.settings.get("proxy", "")),
             },
             "input": {
                 "payloadfile": self.payload_file,
+                "format": self._get_input_format(scenario),
+                "thinktime": bool(scenario.get("har-think-time", False)),
+                "accesslog": {
+                    "regex": scenario.get("access-log-regex", ""),
+                    "timeformat": scenario.get("access-log-time-format", ""),
+                    "address": scenario.get("default-address", ""),
+                },
+                "speed": float(scenario.get("replay-speed", 1)),
                 "stringsfile": self.input_strings if self.input_strings else "",
                 "iterationlimit": load.iterations,
                 "enableregexes": bool(use_regex),
+                "cacheinmemory": bool(scenario.get("cache-payload", self.settings.get("cache-payload", False))),
+                "csvfeeds": self._get_csv_feeds(scenario),
+                "order": scenario.get("input-order", "sequential"),
+                "seed": int(scenario.get("input-seed", 0)),
+                "labelweights": scenario.get("label-weights", {}),
             },
             "output": {
                 "reqrespfile": self.engine.create_artifact("encarno_trace", ".txt") if trace_level < 1000 else "",
@@ -237,6 +259,15 @@ class EncarnoFilesGenerator(object):
             }
         }
 
+        if cfg["input"]["format"] == "accesslog":  # recorded timestamps are used as open workload schedule
+            cfg["workers"]["mode"] = "open"
+        elif scenario.get("schedule-from-input", False):
+            cfg["workers"]["mode"] = "file"
+
+        protocol_options = scenario.get("protocol-options", {})
+        if protocol_options:
+            cfg["protocol"]["options"] = protocol_options
+
         self.output_format = self.settings.get("output-format", self.output_format)
         self.kpi_file = self.engine.create_artifact("encarno_results", "." + self.output_format)
         if self.output_format == "bin":
@@ -360,6 +391,10 @@ class EncarnoFilesGenerator(object):
         else:
             metadata = self._get_metadata_strings(request, host, consumes, ext_tpls, asserts, tcp_payload)
 
+        weight = request.config.get("weight", None)
+        if weight is not None:
+            metadata["weight"] = float(weight)
+
         return metadata, tcp_payload
 
     def _get_metadata_strings(self, request, host, consumes, ext_tpls, asserts, tcp_payload):
@@ -402,7 +437,10 @@ class EncarnoFilesGenerator(object):
         if ext_tpls:
             metadata["e"] = []
             for varname, cfg in ext_tpls.items():
-                s_data = "%s %d %d %s" % (varname, cfg.get('matchNo', 0), cfg.get('groupNo', 1), cfg['re'])
+                if cfg.get('kind'):
+                    s_data = "%s %d %s %s" % (varname, cfg.get('matchNo', 0), cfg['kind'], cfg['expr'])
+                else:
+                    s_data = "%s %d %d %s" % (varname, cfg.get('matchNo', 0), cfg.get('groupNo', 1), cfg['re'])
                 if s_data not in str_list:
                     str_list.append(s_data)
 
@@ -411,7 +449,10 @@ class EncarnoFilesGenerator(object):
         if asserts:
             metadata["c"] = []
             for x in asserts:
-                item = "%s %s" % (1 if x.get("invert") else 0, x["re"])
+                if x.get("kind"):  # expression may contain spaces, so it goes as JSON
+                    item = json.dumps(x, sort_keys=True)
+                else:
+                    item = "%s %s" % (1 if x.get("invert") else 0, x["re"])
                 if item not in str_list:
                     str_list.append(item)
 
@@ -419,11 +460,45 @@ class EncarnoFilesGenerator(object):
 
         return metadata
 
+    def _get_input_format(self, scenario):
+        fmt = scenario.get("input-format", None)
+        if fmt:
+            return fmt
+
+        fname = self.payload_file.lower()
+        for ext in (".gz", ".zst"):  # compressed files are read by encarno on the fly
+            if fname.endswith(ext):
+                fname = fname[:-len(ext)]
+
+        if fname.endswith(".har"):
+            return "har"
+        return "native"  # access logs are not guessed, as .log files are common and switch workload to open
+
+    def _get_csv_feeds(self, scenario):
+        feeds = []
+        sources = scenario.get("data-sources", [])
+        for idx in range(len(sources)):
+            source = ensure_is_dict(sources, idx, "path")
+            names = source.get("variable-names", "")
+            feeds.append({
+                "file": self.engine.find_file(source["path"]),
+                "columns": [x.strip() for x in names.split(",")] if names else None,
+                "delimiter": source.get("delimiter", ","),
+                "mode": "random" if source.get("random-order", False) else source.get("mode", "shared"),
+                "stoponeof": not source.get("loop", True),
+                "perworker": source.get("per-worker", False),
+            })
+        return feeds
+
     def _get_consumers(self, all_consumes, host, request, tcp_payload):
-        consumes = re.findall(r'\$\{([A-Za-z]\w+)}', tcp_payload)
-        consumes.extend(re.findall(r'\$\{([A-Za-z]\w+)}', host))
-        consumes.extend(re.findall(r'\$\{([A-Za-z]\w+)}', request.label))
-        all_consumes.update(consumes)
+        consumes = []
+        for text in (tcp_payload, host, request.label):
+            for expr in TEMPLATE_RE.findall(text):
+                if expr not in consumes:
+                    consumes.append(expr)
+
+        # only variables make use of extracted values, functions like ${:uuid:} don't
+        all_consumes.update(x.split("|")[0] for x in consumes if not x.startswith(":"))
         return consumes
 
     def _get_extractors(self, request):
@@ -436,6 +511,21 @@ class EncarnoFilesGenerator(object):
                 "groupNo": cfg.get('template', 1),
                 "re": cfg['regexp']
             }
+
+        for kind in ("jsonpath", "header", "cookie"):
+            extractors = request.config.get("extract-%s" % kind, {})
+            for varname in extractors:
+                cfg = ensure_is_dict(extractors, varname, kind)
+                ext_tpls[varname] = {
+                    "kind": kind,
+                    "matchNo": cfg.get('match-no', 0),
+                    "expr": cfg[kind],
+                }
+
+        status_vars = request.config.get("extract-status", [])
+        for varname in [status_vars] if isinstance(status_vars, str) else status_vars:
+            ext_tpls[varname] = {"kind": "status", "expr": ""}
+
         return ext_tpls
 
     def _get_asserts(self, req):
@@ -446,13 +536,41 @@ class EncarnoFilesGenerator(object):
             if not isinstance(assertion['contains'], list):
                 assertion['contains'] = [assertion['contains']]
 
-            item = {"re": assertion['contains'][0]}
+            if assertion.get('subject') == 'http-code':
+                item = {"kind": "status", "expr": ",".join(str(x) for x in assertion['contains'])}
+            else:
+                item = {"re": assertion['contains'][0]}
             if assertion.get('not', False):
                 item['invert'] = True
             res.append(item)
 
+        for assertion in req.config.get("assert-jsonpath", []):
+            item = {"kind": "jsonpath", "expr": assertion['jsonpath']}
+            if assertion.get('validate', 'expected-value' in assertion):
+                item['value'] = self._json_value_str(assertion.get('expected-value'))
+            if assertion.get('invert', False):
+                item['invert'] = True
+            res.append(item)
+
+        for kind in ("status", "elapsed", "size"):
+            if req.config.get("assert-%s" % kind):
+                res.append({"kind": kind, "expr": str(req.config.get("assert-%s" % kind))})
+
+        for name, value in req.config.get("assert-header", {}).items():
+            item = {"kind": "header", "expr": name}
+            if value is not None:
+                item['value'] = str(value)
+            res.append(item)
+
         return res
 
+    @staticmethod
+    def _json_value_str(value):
+        # the way encarno renders JSONPath results: strings without quotes, other values as compact JSON
+        if isinstance(value, str):
+            return value
+        return json.dumps(value, separators=(',', ':'))
+
     def _build_request(self, request: HTTPRequest, scenario: Scenario):
         host_url, netloc, path = self._get_request_path(request, scenario)
         payload = "%s %s HTTP/1.1\r\n" % (request.method, path)
@@ -471,7 +589,7 @@ class EncarnoFilesGenerator(object):
             raise TaurusConfigError(msg % (type(request.body), request.body))
 
         if body:
-            if re.findall(r'\$\{([A-Za-z]\w+)}', body):
+            if TEMPLATE_RE.findall(body):
                 headers.merge({"Content-Length": "${:content-length:}"})
             else:
                 headers.merge({"Content-Length": len(body)})
@@ -524,6 +642,30 @@ def ns2sec(val):
     return int(val) / 1000000000.0
 
 
+class ConnBreakdown:
+    """
+    Accumulates DNS, TCP connect and TLS handshake times of newly opened connections
+    """
+
+    def __init__(self):
+        self.count = 0
+        self.dns = 0.0
+        self.dial = 0.0
+        self.tls = 0.0
+
+    def add(self, dns, dial, tls):
+        if dns or dial or tls:
+            self.count += 1
+            self.dns += dns
+            self.dial += dial
+            self.tls += tls
+
+    def __str__(self):
+        cnt = max(self.count, 1)
+        return "%d opened, avg DNS %.3fs, TCP connect %.3fs, TLS handshake %.3fs" % (
+            self.count, self.dns / cnt, self.dial / cnt, self.tls / cnt)
+
+
 class KPIReaderLDJSON(ResultsReader):
     """
     Class to read KPI from LDJSON file
@@ -535,6 +677,7 @@ class KPIReaderLDJSON(ResultsReader):
         self.file = FileReader(filename=filename, parent_logger=self.log)
         self.partial_buffer = ""
         self.health_reader = HealthReader(health_filename, parent_logger)
+        self.breakdown = ConnBreakdown()
 
     def _read(self, last_pass=False):
         """
@@ -566,6 +709,8 @@ class KPIReaderLDJSON(ResultsReader):
                 rtm = ns2sec(row["Elapsed"])
                 ltc = ns2sec(row["FirstByteTime"])
                 cnn = ns2sec(row["ConnectTime"])
+                self.breakdown.add(ns2sec(row.get("DNSTime", 0)), ns2sec(row.get("DialTime", 0)),
+                                   ns2sec(row.get("TLSTime", 0)))
                 # NOTE: actually we have precise send and receive time here...
             except BaseException:
                 raise ToolError("Reader: failed record: %s" % row)
@@ -573,7 +718,7 @@ class KPIReaderLDJSON(ResultsReader):
             error = row["ErrorStr"] if row["ErrorStr"] else None
             rcd = str(row["Status"])
 
-            if row["Status"] >= 400 and not error:  # TODO: should this be under config flag?
+            if 400 <= row["Status"] < 600 and not error:  # TODO: should this be under config flag?
                 error = http.HTTPStatus(row["Status"]).phrase
 
             tstmp = int(row["StartTS"])
@@ -588,9 +733,13 @@ class KPIReaderLDJSON(ResultsReader):
 
 class KPIReaderBinary(ResultsReader):
     """
-    Class to read KPI from LDJSON file
+    Class to read KPI from binary file, legacy files have no header and lack connection breakdown
     """
-    FORMAT = "<L HH L 5d LH QQ"
+    MAGIC = b"ENCB"
+    HEADER_FORMAT = "<4s HH"
+    HEADER_LEN = struct.calcsize(HEADER_FORMAT)
+    FORMAT_V1 = "<L HH L 5d LH QQ"
+    FORMAT = "<L HH L 8d LH QQ"
     CHUNK_LEN = struct.calcsize(FORMAT)
 
     def __init__(self, filename, str_filename, parent_logger, health_filename):
@@ -601,6 +750,8 @@ class KPIReaderBinary(ResultsReader):
         self.str_map = {0: ""}
         self.partial_buffer = bytes()
         self.health_reader = HealthReader(health_filename, parent_logger)
+        self.breakdown = ConnBreakdown()
+        self.version = None
 
     def _read(self, last_pass=False):
         """
@@ -618,11 +769,30 @@ class KPIReaderBinary(ResultsReader):
         dlen = len(data)
 
         offset = 0
+        if self.version is None:
+            if dlen < self.HEADER_LEN:
+                self.partial_buffer = data
+                return
+
+            if data.startswith(self.MAGIC):
+                _, self.version, chunk_len = struct.unpack_from(self.HEADER_FORMAT, data)
+                if chunk_len != self.CHUNK_LEN:
+                    raise ToolError("Unsupported binary results format version %s" % self.version)
+                offset = self.HEADER_LEN
+            else:
+                self.version = 1
+                self.FORMAT = self.FORMAT_V1
+                self.CHUNK_LEN = struct.calcsize(self.FORMAT_V1)
+
         while (offset + self.CHUNK_LEN) <= dlen:
             item = struct.unpack_from(self.FORMAT, data, offset)
             offset += self.CHUNK_LEN
 
-            tstmp, rcd, err_idx, concur, rtm, cnn, sent, ltc, recv, wrk, lbl_idx, sbytes, rbytes = item
+            if self.version == 1:
+                tstmp, rcd, err_idx, concur, rtm, cnn, sent, ltc, recv, wrk, lbl_idx, sbytes, rbytes = item
+            else:
+                tstmp, rcd, err_idx, concur, rtm, cnn, dns, dial, tls, sent, ltc, recv, wrk, lbl_idx, sbytes, rbytes = item
+                self.breakdown.add(dns, dial, tls)
 
             error = None
             if err_idx > 0:
@@ -630,7 +800,7 @@ class KPIReaderBinary(ResultsReader):
 
             label = self._get_strindex(lbl_idx)
 
-            if rcd >= 400 and not error:  # TODO: should this be under config flag?
+            if 400 <= rcd < 600 and not error:  # TODO: should this be under config flag?
                 error = http.HTTPStatus(rcd).phrase
 
             byte_count = sbytes + rbytes